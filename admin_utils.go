@@ -0,0 +1,258 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/WaronLimsakul/Chirpy/internal/audit"
+	"github.com/WaronLimsakul/Chirpy/internal/auth"
+	"github.com/WaronLimsakul/Chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// list users, oldest first. ?limit= (default 50, max 100) and ?offset=
+// behind requireRole(admin)
+func (cfg *apiConfig) listUsers(w http.ResponseWriter, r *http.Request) {
+	limit, offset := 50, 0
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > 100 {
+			w.WriteHeader(400)
+			return
+		}
+		limit = n
+	}
+	if s := r.URL.Query().Get("offset"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			w.WriteHeader(400)
+			return
+		}
+		offset = n
+	}
+
+	users, err := cfg.dbQueries.ListUsers(r.Context(), database.ListUsersParams{
+		Limit:  int32(limit),
+		Offset: int32(offset),
+	})
+	if err != nil {
+		log.Printf("error listing users: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	resUsers := []User{}
+	for _, user := range users {
+		resUsers = append(resUsers, toUser(user))
+	}
+
+	resData, err := json.Marshal(resUsers)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(resData)
+}
+
+// body: {"role": "user" | "moderator" | "admin"}
+// behind requireRole(admin). admins can't change their own role,
+// so the last admin can't lock everyone out by accident.
+func (cfg *apiConfig) setUserRole(w http.ResponseWriter, r *http.Request) {
+	claims, _ := claimsFromContext(r.Context())
+
+	userID, err := uuid.Parse(r.PathValue("user_id"))
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	if userID == claims.UserID {
+		w.WriteHeader(403)
+		return
+	}
+
+	type reqBodyStruct struct {
+		Role string `json:"role"`
+	}
+	reqBody := reqBodyStruct{}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	role, err := auth.ParseRole(reqBody.Role)
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	user, err := cfg.dbQueries.SetUserRole(r.Context(), database.SetUserRoleParams{
+		Role: string(role),
+		ID:   userID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(404)
+		return
+	} else if err != nil {
+		log.Printf("error setting role: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	log.Printf("admin %s set role of %s to %s", claims.UserID, user.ID, role)
+	cfg.audit(r, audit.Event{
+		Action:   audit.ActionRoleChanged,
+		ActorID:  claims.UserID,
+		TargetID: user.ID,
+		Detail:   "role " + string(role),
+	})
+
+	resData, err := json.Marshal(toUser(user))
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(resData)
+}
+
+// delete a user with all their chirps and tokens (db cascades), chirps with
+// replies stay as tombstones
+// behind requireRole(admin), same self rule as setUserRole
+func (cfg *apiConfig) deleteUser(w http.ResponseWriter, r *http.Request) {
+	claims, _ := claimsFromContext(r.Context())
+
+	userID, err := uuid.Parse(r.PathValue("user_id"))
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	if userID == claims.UserID {
+		w.WriteHeader(403)
+		return
+	}
+
+	deleted, err := cfg.deleteUserKeepingThreads(r.Context(), userID)
+	if err != nil {
+		log.Printf("error deleting user: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if deleted == 0 {
+		w.WriteHeader(404)
+		return
+	}
+	log.Printf("admin %s deleted user %s", claims.UserID, userID)
+	cfg.audit(r, audit.Event{
+		Action:   audit.ActionUserDeleted,
+		ActorID:  claims.UserID,
+		TargetID: userID,
+	})
+
+	w.WriteHeader(204)
+}
+
+// search the audit log, newest first. behind requireRole(admin)
+// filters (all optional): ?user_id= (actor or target), ?action=, ?outcome=,
+// ?ip=, ?since= and ?until= (RFC 3339)
+// pages: ?limit= (default 50, max 200), then ?before_id=<next_before_id of the last page>
+func (cfg *apiConfig) listAuditEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := audit.Filter{
+		Action:  audit.Action(query.Get("action")),
+		Outcome: audit.Outcome(query.Get("outcome")),
+		IP:      query.Get("ip"),
+		Limit:   50,
+	}
+
+	var err error
+	if s := query.Get("user_id"); s != "" {
+		if filter.UserID, err = uuid.Parse(s); err != nil {
+			w.WriteHeader(400)
+			return
+		}
+	}
+	if s := query.Get("since"); s != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, s); err != nil {
+			w.WriteHeader(400)
+			return
+		}
+	}
+	if s := query.Get("until"); s != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, s); err != nil {
+			w.WriteHeader(400)
+			return
+		}
+	}
+	if s := query.Get("before_id"); s != "" {
+		filter.BeforeID, err = strconv.ParseInt(s, 10, 64)
+		if err != nil || filter.BeforeID < 1 {
+			w.WriteHeader(400)
+			return
+		}
+	}
+	if s := query.Get("limit"); s != "" {
+		filter.Limit, err = strconv.Atoi(s)
+		if err != nil || filter.Limit < 1 || filter.Limit > 200 {
+			w.WriteHeader(400)
+			return
+		}
+	}
+
+	events, err := cfg.dbQueries.ListAuditEvents(r.Context(), filter.Params())
+	if err != nil {
+		log.Printf("error listing audit events: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	type resBodyStruct struct {
+		Events []AuditEvent `json:"events"`
+		// pass as ?before_id= for the next page, missing on the last one
+		NextBeforeID int64 `json:"next_before_id,omitempty"`
+	}
+	res := resBodyStruct{Events: []AuditEvent{}}
+	for _, event := range events {
+		res.Events = append(res.Events, toAuditEvent(event))
+	}
+	if len(events) == filter.Limit {
+		res.NextBeforeID = events[len(events)-1].ID
+	}
+
+	resData, err := json.Marshal(res)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(resData)
+}
+
+func toAuditEvent(event database.AuditEvent) AuditEvent {
+	res := AuditEvent{
+		ID:        event.ID,
+		CreatedAt: event.CreatedAt,
+		Action:    event.Action,
+		Outcome:   event.Outcome,
+		IPAddress: event.IpAddress,
+		UserAgent: event.UserAgent,
+		Detail:    event.Detail,
+	}
+	if event.ActorID.Valid {
+		res.ActorID = &event.ActorID.UUID
+	}
+	if event.TargetID.Valid {
+		res.TargetID = &event.TargetID.UUID
+	}
+	return res
+}
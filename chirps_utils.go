@@ -1,14 +1,20 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"sort"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/WaronLimsakul/Chirpy/internal/auth"
 	"github.com/WaronLimsakul/Chirpy/internal/database"
+	"github.com/WaronLimsakul/Chirpy/internal/entitlements"
 	"github.com/google/uuid"
 )
 
@@ -75,27 +81,36 @@ import (
 // 	w.Write(resData)
 // }
 
-// 0. validate user by token in header
-// 1. validate chirp (get the above func's logic)
-// 2. create chrip in db
+// 0. validate user by token in header (+ verified email if REQUIRE_VERIFIED_EMAIL)
+// 1. check the user's plan allows it: length, scheduling, and posts per hour
+// (that one in the insert's tx)
+// 2. create chrip in db, "publish_at" in body schedules it for later,
+// "in_reply_to" makes it a reply to a published chirp
 // 3. return new chirp in json form
 func (cfg *apiConfig) createChirp(w http.ResponseWriter, r *http.Request) {
-	token, err := auth.GetBearerToken(r.Header)
+	claims, err := cfg.authenticate(r, auth.ScopeChirpsWrite)
 	if err != nil {
 		log.Printf("%s", err)
-		w.WriteHeader(400)
+		w.WriteHeader(authErrorStatus(err))
 		return
 	}
+	userID := claims.UserID
 
-	userID, err := auth.ValidateJWT(token, cfg.tokenSecret)
+	user, limits, err := cfg.limitsFor(r.Context(), userID)
 	if err != nil {
-		log.Printf("%s", err)
 		w.WriteHeader(401)
 		return
 	}
 
+	if cfg.requireVerifiedEmail && !user.EmailVerifiedAt.Valid {
+		writeChirpError(w, 403, "verify your email before posting")
+		return
+	}
+
 	type reqBodyStruct struct {
-		Body string `json:"body"`
+		Body      string     `json:"body"`
+		PublishAt *time.Time `json:"publish_at"`
+		InReplyTo *uuid.UUID `json:"in_reply_to"`
 	}
 
 	decoder := json.NewDecoder(r.Body)
@@ -106,16 +121,160 @@ func (cfg *apiConfig) createChirp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if len(req.Body) > 140 {
-		w.WriteHeader(400)
-		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		w.Write([]byte("chirp is too long"))
+	// 1.
+	if !checkChirpLength(w, req.Body, limits) {
+		return
+	}
+
+	// a time in the past just means now
+	publishAt := sql.NullTime{}
+	if req.PublishAt != nil && req.PublishAt.After(time.Now()) {
+		if !limits.CanSchedule() {
+			writeChirpError(w, 403, "scheduled chirps are a Chirpy Red perk")
+			return
+		}
+		if req.PublishAt.After(time.Now().Add(limits.ScheduleAhead)) {
+			writeChirpError(w, 400, fmt.Sprintf("chirps can be scheduled up to %s ahead", limits.ScheduleAhead))
+			return
+		}
+		publishAt = sql.NullTime{Time: *req.PublishAt, Valid: true}
+	}
+
+	// only to what others can see too
+	inReplyTo := uuid.NullUUID{}
+	if req.InReplyTo != nil {
+		parent, err := cfg.dbQueries.GetChirpByID(r.Context(), *req.InReplyTo)
+		if err != nil || parent.DeletedAt.Valid || parent.PublishAt.After(time.Now()) {
+			writeChirpError(w, 400, "the chirp to reply to doesn't exist")
+			return
+		}
+		inReplyTo = uuid.NullUUID{UUID: parent.ID, Valid: true}
+	}
+
+	// 2.
+	params := database.CreateChirpParams{
+		Body:      cleanChirpBody(req.Body),
+		UserID:    userID,
+		PublishAt: publishAt,
+		InReplyTo: inReplyTo,
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("%s", err)
+		w.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+	queries := cfg.dbQueries.WithTx(tx)
+
+	// posts per hour, counted in the tx with the user locked, so parallel posts
+	// wait for each other instead of all passing the count. scheduled ones
+	// count when they were posted, deleted ones still count
+	if limits.ChirpsPerHour > 0 {
+		if _, err := queries.LockUser(r.Context(), userID); err != nil {
+			log.Printf("error locking user: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+
+		posted, err := queries.CountChirpPostsSince(r.Context(), database.CountChirpPostsSinceParams{
+			UserID:   userID,
+			PostedAt: time.Now().Add(-time.Hour),
+		})
+		if err != nil {
+			log.Printf("error counting chirps: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+		if posted >= int64(limits.ChirpsPerHour) {
+			writeChirpError(w, 429, fmt.Sprintf("you can post %d chirps per hour", limits.ChirpsPerHour))
+			return
+		}
+	}
+
+	newChirp, err := queries.CreateChirp(r.Context(), params)
+	if err != nil {
+		log.Printf("error creating chirp: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if err := queries.RecordChirpPost(r.Context(), userID); err != nil {
+		log.Printf("error recording chirp post: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+	err = queries.PruneChirpPosts(r.Context(), database.PruneChirpPostsParams{
+		UserID:   userID,
+		PostedAt: time.Now().Add(-time.Hour),
+	})
+	if err != nil {
+		log.Printf("error pruning chirp posts: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if err := cfg.timeline(queries).ChirpPosted(r.Context(), newChirp); err != nil {
+		log.Printf("error adding chirp to timelines: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("%s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	// 3.
+	resChirp, err := cfg.expandChirp(r.Context(), newChirp)
+	if err != nil {
+		log.Printf("error getting chirp author: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	resData, err := json.Marshal(resChirp)
+	if err != nil {
+		log.Println("error marshalling response body")
+		w.WriteHeader(500)
 		return
 	}
 
-	resBodyWords := strings.Fields(req.Body)
+	w.WriteHeader(201)
+	w.Write(resData)
+}
+
+// the user and what their plan allows
+func (cfg *apiConfig) limitsFor(ctx context.Context, userID uuid.UUID) (database.User, entitlements.Limits, error) {
+	user, err := cfg.dbQueries.GetUserByID(ctx, userID)
+	if err != nil {
+		return database.User{}, entitlements.Limits{}, err
+	}
+	return user, cfg.entitlements.For(entitlements.PlanOf(user.IsChirpyRed)), nil
+}
+
+func writeChirpError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write([]byte(message))
+}
+
+// true if body fits the plan, otherwise 400 is written.
+// counted in characters, not bytes, so an emoji isn't 4 of them
+func checkChirpLength(w http.ResponseWriter, body string, limits entitlements.Limits) bool {
+	if utf8.RuneCountInString(body) > limits.MaxChirpLength {
+		writeChirpError(w, 400, fmt.Sprintf("chirp is too long, max %d characters", limits.MaxChirpLength))
+		return false
+	}
+	return true
+}
+
+// replace kerfuffle/sharbert/fornax with ****
+func cleanChirpBody(body string) string {
+	resBodyWords := strings.Fields(body)
 	for i, word := range resBodyWords {
-		// 3. replace kerfuffle/sharbert/fornax with **
 		lower := strings.ToLower(word)
 		if lower == "kerfuffle" {
 			resBodyWords[i] = "****"
@@ -126,31 +285,202 @@ func (cfg *apiConfig) createChirp(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	cleanedBody := strings.Join(resBodyWords, " ")
+	return strings.Join(resBodyWords, " ")
+}
 
-	params := database.CreateChirpParams{
-		Body:   cleanedBody,
-		UserID: userID,
+func toChirp(chirp database.Chirp) Chirp {
+	res := Chirp{
+		ID:        chirp.ID,
+		CreatedAt: chirp.CreatedAt,
+		UpdatedAt: chirp.UpdatedAt,
+		PublishAt: chirp.PublishAt,
+		Body:      chirp.Body,
+		Deleted:   chirp.DeletedAt.Valid,
 	}
+	// a tombstone doesn't say whose it was
+	if chirp.UserID.Valid && !res.Deleted {
+		res.UserID = &chirp.UserID.UUID
+	}
+	if chirp.InReplyTo.Valid {
+		res.InReplyTo = &chirp.InReplyTo.UUID
+	}
+	return res
+}
 
-	newChirp, err := cfg.dbQueries.CreateChirp(r.Context(), params)
+// toChirp for a batch, with the authors and reply counts filled in,
+// one query each for the whole batch
+func (cfg *apiConfig) expandChirps(ctx context.Context, chirps []database.Chirp) ([]Chirp, error) {
+	// 1. distinct author ids, and the chirp ids
+	seen := map[uuid.UUID]bool{}
+	authorIDs := []uuid.UUID{}
+	chirpIDs := []uuid.UUID{}
+	for _, chirp := range chirps {
+		chirpIDs = append(chirpIDs, chirp.ID)
+		if chirp.UserID.Valid && !seen[chirp.UserID.UUID] {
+			seen[chirp.UserID.UUID] = true
+			authorIDs = append(authorIDs, chirp.UserID.UUID)
+		}
+	}
 
-	res := Chirp{
-		ID:        newChirp.ID,
-		CreatedAt: newChirp.CreatedAt,
-		UpdatedAt: newChirp.UpdatedAt,
-		Body:      newChirp.Body,
-		UserID:    newChirp.UserID,
+	// 2.
+	authors := map[uuid.UUID]*ChirpAuthor{}
+	replies := map[uuid.UUID]int64{}
+	if len(chirps) > 0 {
+		summaries, err := cfg.dbQueries.GetUserSummaries(ctx, authorIDs)
+		if err != nil {
+			return nil, err
+		}
+		for _, summary := range summaries {
+			authors[summary.ID] = &ChirpAuthor{
+				ID:          summary.ID,
+				Handle:      summary.Handle.String,
+				DisplayName: summary.DisplayName,
+				IsChirpyRed: summary.IsChirpyRed,
+			}
+		}
+
+		counts, err := cfg.dbQueries.CountReplies(ctx, chirpIDs)
+		if err != nil {
+			return nil, err
+		}
+		for _, count := range counts {
+			replies[count.InReplyTo.UUID] = count.Replies
+		}
+	}
+
+	// 3.
+	resChirps := make([]Chirp, 0, len(chirps))
+	for _, chirp := range chirps {
+		resChirp := toChirp(chirp)
+		if !resChirp.Deleted {
+			resChirp.Author = authors[chirp.UserID.UUID]
+		}
+		resChirp.ReplyCount = replies[chirp.ID]
+		resChirps = append(resChirps, resChirp)
 	}
+	return resChirps, nil
+}
 
-	resData, err := json.Marshal(res)
+// expandChirps for one chirp
+func (cfg *apiConfig) expandChirp(ctx context.Context, chirp database.Chirp) (Chirp, error) {
+	resChirps, err := cfg.expandChirps(ctx, []database.Chirp{chirp})
 	if err != nil {
-		log.Println("error marshalling response body")
+		return Chirp{}, err
+	}
+	return resChirps[0], nil
+}
+
+// body has the new "body". only the author, if their plan has editing,
+// within the edit window after it's published (scheduled chirps until then too)
+func (cfg *apiConfig) editChirp(w http.ResponseWriter, r *http.Request) {
+	claims, err := cfg.authenticate(r, auth.ScopeChirpsWrite)
+	if err != nil {
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+
+	chirpUUID, err := uuid.Parse(r.PathValue("chirp_id"))
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	chirp, err := cfg.dbQueries.GetChirpByID(r.Context(), chirpUUID)
+	if err != nil || chirp.DeletedAt.Valid {
+		w.WriteHeader(404)
+		return
+	}
+
+	if chirp.UserID.UUID != claims.UserID {
+		w.WriteHeader(403)
+		return
+	}
+
+	_, limits, err := cfg.limitsFor(r.Context(), claims.UserID)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+
+	if !limits.CanEdit() {
+		writeChirpError(w, 403, "editing chirps is a Chirpy Red perk")
+		return
+	}
+	if time.Now().After(chirp.PublishAt.Add(limits.EditWindow)) {
+		writeChirpError(w, 403, fmt.Sprintf("chirps can only be edited for %s", limits.EditWindow))
+		return
+	}
+
+	type reqBodyStruct struct {
+		Body string `json:"body"`
+	}
+
+	req := reqBodyStruct{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	if !checkChirpLength(w, req.Body, limits) {
+		return
+	}
+
+	updated, err := cfg.dbQueries.UpdateChirpBody(r.Context(), database.UpdateChirpBodyParams{
+		Body: cleanChirpBody(req.Body),
+		ID:   chirp.ID,
+	})
+	if err != nil {
+		log.Printf("error updating chirp: %s", err)
 		w.WriteHeader(500)
 		return
 	}
 
-	w.WriteHeader(201)
+	resChirp, err := cfg.expandChirp(r.Context(), updated)
+	if err != nil {
+		log.Printf("error getting chirp author: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	resData, err := json.Marshal(resChirp)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(resData)
+}
+
+// the user's chirps that aren't published yet
+func (cfg *apiConfig) listScheduledChirps(w http.ResponseWriter, r *http.Request) {
+	claims, err := cfg.authenticate(r, auth.ScopeChirpsRead)
+	if err != nil {
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+
+	chirps, err := cfg.dbQueries.ListScheduledChirps(r.Context(), claims.UserID)
+	if err != nil {
+		log.Printf("error listing scheduled chirps: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	resChirps, err := cfg.expandChirps(r.Context(), chirps)
+	if err != nil {
+		log.Printf("error getting chirp authors: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	resData, err := json.Marshal(resChirps)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
 	w.Write(resData)
 }
 
@@ -185,16 +515,11 @@ func (cfg *apiConfig) getAllChirps(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	var resChirps []Chirp
-	for _, chirp := range chirps {
-		resChirp := Chirp{
-			ID:        chirp.ID,
-			CreatedAt: chirp.CreatedAt,
-			UpdatedAt: chirp.UpdatedAt,
-			Body:      chirp.Body,
-			UserID:    chirp.UserID,
-		}
-		resChirps = append(resChirps, resChirp)
+	resChirps, err := cfg.expandChirps(r.Context(), chirps)
+	if err != nil {
+		log.Printf("%s", err)
+		w.WriteHeader(500)
+		return
 	}
 
 	sortOrder := r.URL.Query().Get("sort")
@@ -202,11 +527,11 @@ func (cfg *apiConfig) getAllChirps(w http.ResponseWriter, r *http.Request) {
 		// the lambda receive 2 indeces, return true if you want the first
 		// one to come first
 		sort.Slice(resChirps, func(i int, j int) bool {
-			return resChirps[i].CreatedAt.After(resChirps[j].CreatedAt)
+			return resChirps[i].PublishAt.After(resChirps[j].PublishAt)
 		})
 	} else {
 		sort.Slice(resChirps, func(i int, j int) bool {
-			return resChirps[i].CreatedAt.Before(resChirps[j].CreatedAt)
+			return resChirps[i].PublishAt.Before(resChirps[j].PublishAt)
 		})
 	}
 
@@ -235,12 +560,17 @@ func (cfg *apiConfig) getChirpByID(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resChirp := Chirp{
-		ID:        chirp.ID,
-		CreatedAt: chirp.CreatedAt,
-		UpdatedAt: chirp.UpdatedAt,
-		Body:      chirp.Body,
-		UserID:    chirp.UserID,
+	// scheduled, doesn't exist yet for others. tombstones only show in threads
+	if chirp.PublishAt.After(time.Now()) || chirp.DeletedAt.Valid {
+		w.WriteHeader(404)
+		return
+	}
+
+	resChirp, err := cfg.expandChirp(r.Context(), chirp)
+	if err != nil {
+		log.Printf("error getting chirp author: %s", err)
+		w.WriteHeader(500)
+		return
 	}
 
 	resData, err := json.Marshal(resChirp)
@@ -255,17 +585,16 @@ func (cfg *apiConfig) getChirpByID(w http.ResponseWriter, r *http.Request) {
 }
 
 // check token in the header => get a user id
-// use userid +
+// only the author can delete, or a moderator (any chirp).
+// a chirp with replies is left as a tombstone
 func (cfg *apiConfig) deleteChirp(w http.ResponseWriter, r *http.Request) {
-	reqToken, err := auth.GetBearerToken(r.Header)
+	claims, err := cfg.authenticate(r, auth.ScopeChirpsWrite)
 	if err != nil {
 		log.Printf("error geting token in deleteChirp: %s", err)
-		w.WriteHeader(401)
+		w.WriteHeader(authErrorStatus(err))
 		return
 	}
 
-	userID, err := auth.ValidateJWT(reqToken, cfg.tokenSecret)
-
 	chirpID := r.PathValue("chirp_id")
 	if chirpID == "" {
 		w.WriteHeader(400)
@@ -278,15 +607,59 @@ func (cfg *apiConfig) deleteChirp(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	chirp, err := cfg.dbQueries.GetChirpByID(r.Context(), chirpUUID)
-	if chirp.UserID != userID {
+	// the check for replies and the delete / tombstone in one tx, with the chirp
+	// locked so no reply can come in between
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("%s", err)
+		w.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+	queries := cfg.dbQueries.WithTx(tx)
+
+	chirp, err := queries.LockChirp(r.Context(), chirpUUID)
+	if err != nil || chirp.DeletedAt.Valid {
+		w.WriteHeader(404)
+		return
+	}
+
+	if chirp.UserID.UUID != claims.UserID && !claims.Role.Allows(auth.RoleModerator) {
 		w.WriteHeader(403)
 		return
 	}
 
-	err = cfg.dbQueries.DeleteChirpByID(r.Context(), chirpUUID)
+	// moderator powers can be revoked, the role in token may be old
+	if chirp.UserID.UUID != claims.UserID {
+		moderator, err := queries.GetUserByID(r.Context(), claims.UserID)
+		if err != nil || !auth.Role(moderator.Role).Allows(auth.RoleModerator) {
+			w.WriteHeader(403)
+			return
+		}
+	}
+
+	// with replies it stays as a tombstone, or the thread would fall apart
+	hasReplies, err := queries.HasReplies(r.Context(), uuid.NullUUID{UUID: chirpUUID, Valid: true})
+	if err != nil {
+		log.Printf("error checking replies: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if hasReplies {
+		err = queries.TombstoneChirp(r.Context(), chirpUUID)
+	} else {
+		err = queries.DeleteChirpByID(r.Context(), chirpUUID)
+	}
 	if err != nil {
-		w.WriteHeader(404)
+		log.Printf("error deleting chirp: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("%s", err)
+		w.WriteHeader(500)
 		return
 	}
 
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/WaronLimsakul/Chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+var errSelfFollow = errors.New("you can't follow yourself")
+
+// the user in the token follows {user_id}
+// - 400 for yourself, 404 if there's no such user, 409 if already following
+func (cfg *apiConfig) followUser(w http.ResponseWriter, r *http.Request) {
+	claims, err := cfg.authenticate(r, accountOnly)
+	if err != nil {
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+
+	followeeID, err := uuid.Parse(r.PathValue("user_id"))
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	if followeeID == claims.UserID {
+		writeProfileError(w, errSelfFollow)
+		return
+	}
+
+	if _, err := cfg.dbQueries.GetUserByID(r.Context(), followeeID); err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("%s", err)
+		w.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+	queries := cfg.dbQueries.WithTx(tx)
+
+	rows, err := queries.FollowUser(r.Context(), database.FollowUserParams{
+		FollowerID: claims.UserID,
+		FolloweeID: followeeID,
+	})
+	if err != nil {
+		// the user got deleted since we checked
+		log.Printf("error following user: %s", err)
+		w.WriteHeader(404)
+		return
+	}
+
+	if rows == 0 {
+		w.WriteHeader(409)
+		return
+	}
+
+	if err := cfg.timeline(queries).Followed(r.Context(), claims.UserID, followeeID); err != nil {
+		log.Printf("error backfilling timeline: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("%s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+// 404 if the user in the token doesn't follow {user_id}
+func (cfg *apiConfig) unfollowUser(w http.ResponseWriter, r *http.Request) {
+	claims, err := cfg.authenticate(r, accountOnly)
+	if err != nil {
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+
+	followeeID, err := uuid.Parse(r.PathValue("user_id"))
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("%s", err)
+		w.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+	queries := cfg.dbQueries.WithTx(tx)
+
+	rows, err := queries.UnfollowUser(r.Context(), database.UnfollowUserParams{
+		FollowerID: claims.UserID,
+		FolloweeID: followeeID,
+	})
+	if err != nil {
+		log.Printf("error unfollowing user: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if rows == 0 {
+		w.WriteHeader(404)
+		return
+	}
+
+	if err := cfg.timeline(queries).Unfollowed(r.Context(), claims.UserID, followeeID); err != nil {
+		log.Printf("error removing from timeline: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("%s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+// who follows {user_id}, newest first. no token needed
+// pages: ?limit= (default 50, max 200), then ?before_id=<next_before_id of the last page>
+func (cfg *apiConfig) listFollowers(w http.ResponseWriter, r *http.Request) {
+	cfg.listFollows(w, r, func(params database.ListFollowersParams) ([]database.ListFollowersRow, error) {
+		return cfg.dbQueries.ListFollowers(r.Context(), params)
+	})
+}
+
+// who {user_id} follows, same as listFollowers
+func (cfg *apiConfig) listFollowing(w http.ResponseWriter, r *http.Request) {
+	cfg.listFollows(w, r, func(params database.ListFollowersParams) ([]database.ListFollowersRow, error) {
+		rows, err := cfg.dbQueries.ListFollowing(r.Context(), database.ListFollowingParams(params))
+		// same columns, only the join is the other way
+		res := []database.ListFollowersRow{}
+		for _, row := range rows {
+			res = append(res, database.ListFollowersRow(row))
+		}
+		return res, err
+	})
+}
+
+// the shared part of listFollowers and listFollowing: parse the page, run
+// the query, respond with {"users": [...], "next_before_id": ...}
+func (cfg *apiConfig) listFollows(w http.ResponseWriter, r *http.Request,
+	list func(database.ListFollowersParams) ([]database.ListFollowersRow, error)) {
+	// 1.
+	userID, err := uuid.Parse(r.PathValue("user_id"))
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	if _, err := cfg.dbQueries.GetUserByID(r.Context(), userID); err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	// 2.
+	params := database.ListFollowersParams{UserID: userID, MaxResults: 50}
+	query := r.URL.Query()
+	if s := query.Get("before_id"); s != "" {
+		beforeID, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || beforeID < 1 {
+			w.WriteHeader(400)
+			return
+		}
+		params.BeforeID = sql.NullInt64{Int64: beforeID, Valid: true}
+	}
+	if s := query.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > 200 {
+			w.WriteHeader(400)
+			return
+		}
+		params.MaxResults = int32(limit)
+	}
+
+	// 3.
+	rows, err := list(params)
+	if err != nil {
+		log.Printf("error listing follows: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	type resBodyStruct struct {
+		Users []Follow `json:"users"`
+		// pass as ?before_id= for the next page, missing on the last one
+		NextBeforeID int64 `json:"next_before_id,omitempty"`
+	}
+	res := resBodyStruct{Users: []Follow{}}
+	for _, row := range rows {
+		res.Users = append(res.Users, toFollow(row))
+	}
+	if len(rows) == int(params.MaxResults) {
+		res.NextBeforeID = rows[len(rows)-1].ID
+	}
+
+	resData, err := json.Marshal(res)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(resData)
+}
+
+func toFollow(row database.ListFollowersRow) Follow {
+	return Follow{
+		UserID:      row.UserID,
+		Handle:      row.Handle.String,
+		DisplayName: row.DisplayName,
+		IsChirpyRed: row.IsChirpyRed,
+		FollowedAt:  row.CreatedAt,
+	}
+}
@@ -9,4 +9,6 @@ require (
 	golang.org/x/crypto v0.33.0
 )
 
-require github.com/golang-jwt/jwt/v5 v5.2.1 // indirect
+require github.com/golang-jwt/jwt/v5 v5.2.1
+
+require golang.org/x/sys v0.30.0 // indirect
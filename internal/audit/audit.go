@@ -0,0 +1,131 @@
+// Package audit records security-relevant account events (logins, password
+// changes, token use, admin actions...) in the append-only audit_events table.
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/WaronLimsakul/Chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+type Action string
+
+const (
+	ActionUserCreated      Action = "user.created"
+	ActionAccountDeleted   Action = "user.deleted"
+	ActionLogin            Action = "login"
+	ActionTokenRefreshed   Action = "token.refreshed"
+	ActionTokenRevoked     Action = "token.revoked"
+	ActionPasswordChanged  Action = "password.changed"
+	ActionPasswordReset    Action = "password.reset"
+	ActionEmailChange      Action = "email.change_requested"
+	ActionSubscription     Action = "subscription.changed"
+	ActionRoleChanged      Action = "admin.role_changed"
+	ActionUserDeleted      Action = "admin.user_deleted"
+	ActionServerReset      Action = "admin.reset"
+	ActionSigningKeyRotate Action = "admin.key_rotated"
+)
+
+type Outcome string
+
+const (
+	OutcomeSuccess Outcome = "success"
+	OutcomeFailure Outcome = "failure"
+)
+
+// Event is one line of the audit log
+type Event struct {
+	Action  Action
+	Outcome Outcome
+	// who did it, uuid.Nil when we don't know (failed login, webhook...)
+	ActorID uuid.UUID
+	// who it was done to, when that's not the actor (admin actions, webhooks)
+	TargetID  uuid.UUID
+	IP        string
+	UserAgent string
+	Detail    string // short reason or context, never secrets
+}
+
+// Store is where events go, *database.Queries is one
+type Store interface {
+	InsertAuditEvent(ctx context.Context, arg database.InsertAuditEventParams) error
+}
+
+// Logger writes events to a Store.
+// recording never fails the request, a broken audit log is logged instead
+type Logger struct {
+	store   Store
+	timeout time.Duration
+}
+
+func New(store Store) *Logger {
+	return &Logger{store: store, timeout: time.Second * 5}
+}
+
+// user-supplied strings are cut, so nobody can fill the log with one request
+const maxFieldLength = 512
+
+func (l *Logger) Record(ctx context.Context, event Event) {
+	if event.Outcome == "" {
+		event.Outcome = OutcomeSuccess
+	}
+
+	// the request may be over (or canceled) by now, the event still counts
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), l.timeout)
+	defer cancel()
+
+	err := l.store.InsertAuditEvent(ctx, database.InsertAuditEventParams{
+		Action:    string(event.Action),
+		Outcome:   string(event.Outcome),
+		ActorID:   nullUUID(event.ActorID),
+		TargetID:  nullUUID(event.TargetID),
+		IpAddress: truncate(event.IP),
+		UserAgent: truncate(event.UserAgent),
+		Detail:    truncate(event.Detail),
+	})
+	if err != nil {
+		log.Printf("error recording audit event %s (%s): %s", event.Action, event.Outcome, err)
+	}
+}
+
+func nullUUID(id uuid.UUID) uuid.NullUUID {
+	return uuid.NullUUID{UUID: id, Valid: id != uuid.Nil}
+}
+
+func truncate(s string) string {
+	if len(s) <= maxFieldLength {
+		return s
+	}
+	return strings.ToValidUTF8(s[:maxFieldLength], "")
+}
+
+// Filter for listing events, zero values match everything
+type Filter struct {
+	UserID   uuid.UUID // actor or target
+	Action   Action
+	Outcome  Outcome
+	IP       string
+	Since    time.Time
+	Until    time.Time
+	BeforeID int64 // cursor: only events older than this one
+	Limit    int
+}
+
+// Params turns the filter into query params for ListAuditEvents
+func (f Filter) Params() database.ListAuditEventsParams {
+	return database.ListAuditEventsParams{
+		UserID:     nullUUID(f.UserID),
+		Action:     sql.NullString{String: string(f.Action), Valid: f.Action != ""},
+		Outcome:    sql.NullString{String: string(f.Outcome), Valid: f.Outcome != ""},
+		IpAddress:  sql.NullString{String: f.IP, Valid: f.IP != ""},
+		Since:      sql.NullTime{Time: f.Since, Valid: !f.Since.IsZero()},
+		Until:      sql.NullTime{Time: f.Until, Valid: !f.Until.IsZero()},
+		BeforeID:   sql.NullInt64{Int64: f.BeforeID, Valid: f.BeforeID > 0},
+		MaxResults: int32(f.Limit),
+	}
+}
@@ -0,0 +1,83 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/WaronLimsakul/Chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+type fakeStore struct {
+	events []database.InsertAuditEventParams
+	err    error
+	ctxErr error
+}
+
+func (s *fakeStore) InsertAuditEvent(ctx context.Context, arg database.InsertAuditEventParams) error {
+	s.ctxErr = ctx.Err()
+	s.events = append(s.events, arg)
+	return s.err
+}
+
+func TestRecord(t *testing.T) {
+	store := &fakeStore{}
+	logger := New(store)
+
+	actor := uuid.New()
+	logger.Record(context.Background(), Event{
+		Action:    ActionLogin,
+		ActorID:   actor,
+		IP:        "127.0.0.1",
+		UserAgent: strings.Repeat("a", 1000),
+	})
+
+	if len(store.events) != 1 {
+		t.Fatalf("want 1 event, got %d", len(store.events))
+	}
+	got := store.events[0]
+	if got.Action != "login" || got.Outcome != "success" {
+		t.Errorf("unexpected action/outcome %q/%q", got.Action, got.Outcome)
+	}
+	if !got.ActorID.Valid || got.ActorID.UUID != actor || got.TargetID.Valid {
+		t.Errorf("unexpected actor/target %+v/%+v", got.ActorID, got.TargetID)
+	}
+	if len(got.UserAgent) != maxFieldLength {
+		t.Errorf("user agent should be cut to %d, got %d", maxFieldLength, len(got.UserAgent))
+	}
+}
+
+func TestRecordAfterCancel(t *testing.T) {
+	store := &fakeStore{}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	New(store).Record(ctx, Event{Action: ActionLogin, Outcome: OutcomeFailure})
+	if store.ctxErr != nil {
+		t.Errorf("a canceled request shouldn't cancel the insert: %s", store.ctxErr)
+	}
+}
+
+func TestRecordStoreError(t *testing.T) {
+	store := &fakeStore{err: errors.New("db down")}
+	// must not panic or return anything, the request goes on
+	New(store).Record(context.Background(), Event{Action: ActionLogin})
+}
+
+func TestFilterParams(t *testing.T) {
+	params := Filter{Limit: 20}.Params()
+	if params.UserID.Valid || params.Action.Valid || params.Since.Valid || params.BeforeID.Valid || params.MaxResults != 20 {
+		t.Errorf("empty filter should match everything, got %+v", params)
+	}
+
+	user := uuid.New()
+	since := time.Now().Add(-time.Hour)
+	params = Filter{UserID: user, Action: ActionLogin, Outcome: OutcomeFailure, Since: since, BeforeID: 42}.Params()
+	if params.UserID.UUID != user || params.Action.String != "login" || params.Outcome.String != "failure" ||
+		!params.Since.Time.Equal(since) || params.BeforeID.Int64 != 42 || params.Until.Valid {
+		t.Errorf("unexpected params %+v", params)
+	}
+}
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"net/http"
@@ -13,21 +14,26 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// hash with DefaultPasswordHasher (argon2id)
 func HashPassword(password string) (string, error) {
-	cost := 10 // iteration of encryption
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), cost)
-	if err != nil {
-		return "", err
-	}
-	return string(hash), nil
+	return DefaultPasswordHasher.Hash(password)
 }
 
-// return nil if password is correct
+// return nil if password is correct.
+// works for both bcrypt and argon2id hashes, the hash says which one it is
 func CheckPasswordHash(password, hash string) error {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		return checkArgon2Hash(password, hash)
+	}
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err
 }
 
+// CheckDummy of DefaultPasswordHasher
+func CheckDummyPasswordHash(password string) error {
+	return DefaultPasswordHasher.CheckDummy(password)
+}
+
 // JWT is a way to authenticate user after log in (in the session).
 // It store users' data. Server issues a JWT, sign it (use token secret
 // to convert json to long string) and send back to client.
@@ -55,11 +61,15 @@ func MakeJWT(userID uuid.UUID, tokenSecret string, expiresIn time.Duration) (str
 // token secret (which used to sign the string)
 func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
 	claimStruct := jwt.RegisteredClaims{}
-	// keyFunc will check if the token is valid (too lazy, not do it)
+	// keyFunc will check if the token is valid
 	// by intially parsed token, we have method + header + claims to play with
 	// if not valid -> return error, if valid -> return token secret
 	// ParseWithClaims() needs that secret to parse the other part of token
 	keyFunc := func(t *jwt.Token) (interface{}, error) {
+		// only accept HMAC, otherwise someone could send e.g. "alg": "none"
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+		}
 		// return []byte because HS256 belong to HMAC signing
 		// use []byte to verify key
 		return []byte(tokenSecret), nil
@@ -106,14 +116,10 @@ func MakeRefreshToken() (string, error) {
 	return token, nil
 }
 
-// extract api-key from "Authorization" header
-// in "Api <key>" form
-func GetAPIKey(headers http.Header) (string, error) {
-	authorizationHeader := headers.Get("Authorization")
-	apiKey, ok := strings.CutPrefix(authorizationHeader, "ApiKey ")
-	if !ok {
-		return "", fmt.Errorf("Not found api key")
-	}
-
-	return apiKey, nil
+// SHA-256 hex digest of a random token, what we store in db instead of
+// the token itself. No salt/bcrypt needed since tokens are 256-bit random,
+// and being deterministic means we can still look them up by digest.
+func HashToken(token string) string {
+	digest := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(digest[:])
 }
@@ -22,6 +22,14 @@ func TestHashing(t *testing.T) {
 	}
 }
 
+func TestDummyPasswordHash(t *testing.T) {
+	for _, password := range []string{"", "abcdefg", "chirpy dummy password"} {
+		if CheckDummyPasswordHash(password) == nil {
+			t.Errorf("%q shouldn't match the dummy hash", password)
+		}
+	}
+}
+
 func TestJWTFunc(t *testing.T) {
 	testSecrets := []string{"nerhgpodfhgnaorg23902l", "uon;ldsknfhsrdofgj", "ashifoas320r467eryl"}
 	testUUIDs := uuid.UUIDs{uuid.New(), uuid.New(), uuid.New()}
@@ -52,3 +60,18 @@ func TestJWTExpiration(t *testing.T) {
 		t.Logf("expiration test pass\n")
 	}
 }
+
+func TestHashToken(t *testing.T) {
+	token, _ := MakeRefreshToken()
+	if HashToken(token) != HashToken(token) {
+		t.Errorf("same token should give same hash")
+	}
+	if HashToken(token) == token {
+		t.Errorf("hash should not be the token itself")
+	}
+
+	otherToken, _ := MakeRefreshToken()
+	if HashToken(token) == HashToken(otherToken) {
+		t.Errorf("different tokens should give different hashes")
+	}
+}
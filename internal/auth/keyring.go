@@ -0,0 +1,478 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Keyring holds every key we sign access tokens with.
+//   - new tokens are signed by the active key and stamped with its id ("kid" header)
+//   - after a rotation the old key is retired, but it still validates tokens
+//     until the grace period ends, so users don't get logged out all at once
+type Keyring struct {
+	// RWMutex because we validate way more often than we rotate
+	mu        sync.RWMutex
+	keys      map[string]*SigningKey
+	activeKID string
+	grace     time.Duration
+}
+
+// SigningKey is one key in the keyring.
+// HS256 keys sign and verify with the same secret, RS256/EdDSA keys
+// sign with the private key and verify with the public one, so other
+// services can verify our tokens from the JWKS without being able to sign.
+type SigningKey struct {
+	id        string
+	method    jwt.SigningMethod
+	signKey   interface{} // []byte, *rsa.PrivateKey or ed25519.PrivateKey
+	verifyKey interface{} // []byte, *rsa.PublicKey or ed25519.PublicKey
+	retiredAt time.Time   // zero value = still active
+}
+
+func (key *SigningKey) ID() string {
+	return key.id
+}
+
+// "HS256", "RS256" or "EdDSA"
+func (key *SigningKey) Algorithm() string {
+	return key.method.Alg()
+}
+
+// the private key as PKCS#8 PEM, what NewPEMKey reads back.
+// RS256/EdDSA only, an HS256 secret isn't a PEM key
+func (key *SigningKey) PrivateKeyPEM() ([]byte, error) {
+	if key.method == jwt.SigningMethodHS256 {
+		return nil, fmt.Errorf("key %q is an HS256 secret, not a private key", key.id)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key.signKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// shared-secret key, the same thing MakeJWT uses
+func NewHMACKey(kid string, secret []byte) (*SigningKey, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("secret for key %q is empty", kid)
+	}
+	return &SigningKey{
+		id:        kid,
+		method:    jwt.SigningMethodHS256,
+		signKey:   secret,
+		verifyKey: secret,
+	}, nil
+}
+
+// key from a PEM private key, RSA becomes RS256 and Ed25519 becomes EdDSA
+func NewPEMKey(kid string, pemData []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(pemData)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data for key %q", kid)
+	}
+
+	var privateKey interface{}
+	var err error
+	if block.Type == "RSA PRIVATE KEY" {
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	} else {
+		privateKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing key %q: %w", kid, err)
+	}
+
+	return newPrivateKey(kid, privateKey)
+}
+
+// read a PEM private key file
+func LoadPEMKey(kid, path string) (*SigningKey, error) {
+	pemData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewPEMKey(kid, pemData)
+}
+
+// make a fresh random key with the same algorithm as the given one
+func GenerateKeyLike(kid string, like *SigningKey) (*SigningKey, error) {
+	switch like.method {
+	case jwt.SigningMethodRS256:
+		privateKey, err := rsa.GenerateKey(rand.Reader, like.signKey.(*rsa.PrivateKey).N.BitLen())
+		if err != nil {
+			return nil, err
+		}
+		return newPrivateKey(kid, privateKey)
+	case jwt.SigningMethodEdDSA:
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return newPrivateKey(kid, privateKey)
+	default:
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		return NewHMACKey(kid, secret)
+	}
+}
+
+func newPrivateKey(kid string, privateKey interface{}) (*SigningKey, error) {
+	switch privateKey := privateKey.(type) {
+	case *rsa.PrivateKey:
+		if privateKey.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA key %q is smaller than 2048 bits", kid)
+		}
+		return &SigningKey{
+			id:        kid,
+			method:    jwt.SigningMethodRS256,
+			signKey:   privateKey,
+			verifyKey: &privateKey.PublicKey,
+		}, nil
+	case ed25519.PrivateKey:
+		return &SigningKey{
+			id:        kid,
+			method:    jwt.SigningMethodEdDSA,
+			signKey:   privateKey,
+			verifyKey: privateKey.Public().(ed25519.PublicKey),
+		}, nil
+	default:
+		return nil, fmt.Errorf("key %q: only RSA and Ed25519 private keys are supported", kid)
+	}
+}
+
+// grace should be at least as long as the access token lifetime
+func NewKeyring(grace time.Duration) *Keyring {
+	return &Keyring{
+		keys:  map[string]*SigningKey{},
+		grace: grace,
+	}
+}
+
+// add a key that can only validate tokens (e.g. keys from before a restart).
+// its grace period starts now.
+func (k *Keyring) AddRetiredKey(key *SigningKey) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if err := k.checkNewKey(key); err != nil {
+		return err
+	}
+
+	key.retiredAt = time.Now()
+	k.keys[key.id] = key
+	return nil
+}
+
+// make key the active signing key and retire the current one.
+// return the id of the retired key ("" if there was none)
+func (k *Keyring) Rotate(key *SigningKey) (string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if err := k.checkNewKey(key); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	retiredKID := k.activeKID
+	if retiredKID != "" {
+		k.keys[retiredKID].retiredAt = now
+	}
+
+	key.retiredAt = time.Time{}
+	k.keys[key.id] = key
+	k.activeKID = key.id
+
+	// forget keys that can't validate anything anymore
+	for id, key := range k.keys {
+		if k.expired(key, now) {
+			delete(k.keys, id)
+		}
+	}
+
+	return retiredKID, nil
+}
+
+func (k *Keyring) ActiveKey() *SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.keys[k.activeKID]
+}
+
+// audience of the short-lived token between password and TOTP code at login.
+// access tokens have no audience, and ValidateJWT refuses any token that has one,
+// so these can never be used as access tokens.
+const challengeAudience = "chirpy-2fa"
+
+// audience of the token in a magic login link, same idea as challengeAudience
+const magicLinkAudience = "chirpy-magic-link"
+
+// what an access token says about its holder
+type AccessClaims struct {
+	UserID uuid.UUID
+	Role   Role
+	Scopes []Scope // personal access and OAuth tokens only, nil = everything
+	// set for tokens issued to a third-party app (see MakeOAuthJWT)
+	ClientID uuid.UUID
+	GrantID  uuid.UUID
+	// zero for personal access tokens, they come from db
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// jwt claims of access tokens, RegisteredClaims + our own
+type accessTokenClaims struct {
+	Role     Role   `json:"role,omitempty"`
+	Scope    string `json:"scope,omitempty"`     // space separated, like OAuth (RFC 9068)
+	ClientID string `json:"client_id,omitempty"` // OAuth tokens only
+	GrantID  string `json:"grant_id,omitempty"`  // OAuth tokens only
+	jwt.RegisteredClaims
+}
+
+// same as MakeJWT, but signed by the active key with its "kid" in the header,
+// and the user's role in the "role" claim
+func (k *Keyring) MakeJWT(userID uuid.UUID, role Role, expiresIn time.Duration) (string, error) {
+	claim := accessTokenClaims{
+		Role: role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "chirpy",
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
+			Subject:   userID.String(),
+		},
+	}
+	return k.sign(claim)
+}
+
+// same as ValidateJWT, but pick the key from the "kid" header.
+// tokens for other purposes (2FA challenge...) are refused.
+// tokens from before roles existed count as RoleUser.
+func (k *Keyring) ValidateJWT(tokenString string) (AccessClaims, error) {
+	claimStruct := accessTokenClaims{}
+	if err := k.parse(tokenString, &claimStruct); err != nil {
+		return AccessClaims{}, err
+	}
+
+	if len(claimStruct.Audience) > 0 {
+		return AccessClaims{}, fmt.Errorf("not an access token")
+	}
+
+	userID, err := uuid.Parse(claimStruct.Subject)
+	if err != nil {
+		return AccessClaims{}, err
+	}
+
+	role := claimStruct.Role
+	if role == "" {
+		role = RoleUser
+	}
+	claims := AccessClaims{UserID: userID, Role: role}
+	if claimStruct.IssuedAt != nil {
+		claims.IssuedAt = claimStruct.IssuedAt.Time
+	}
+	if claimStruct.ExpiresAt != nil {
+		claims.ExpiresAt = claimStruct.ExpiresAt.Time
+	}
+
+	if claimStruct.ClientID != "" {
+		if claims.ClientID, err = uuid.Parse(claimStruct.ClientID); err != nil {
+			return AccessClaims{}, err
+		}
+		if claims.GrantID, err = uuid.Parse(claimStruct.GrantID); err != nil {
+			return AccessClaims{}, err
+		}
+		// never nil, an OAuth token without scopes can do nothing
+		claims.Scopes = []Scope{}
+		for _, scope := range strings.Fields(claimStruct.Scope) {
+			claims.Scopes = append(claims.Scopes, Scope(scope))
+		}
+	}
+
+	return claims, nil
+}
+
+// access token for a third-party app, limited to the scopes the user granted.
+// always RoleUser, an app never gets moderator/admin powers.
+// NOTE: the caller must check the grant wasn't revoked, see AccessClaims.GrantID
+func (k *Keyring) MakeOAuthJWT(userID, clientID, grantID uuid.UUID, scopes []Scope, expiresIn time.Duration) (string, error) {
+	scopeStrings := []string{}
+	for _, scope := range scopes {
+		scopeStrings = append(scopeStrings, string(scope))
+	}
+
+	claim := accessTokenClaims{
+		Role:     RoleUser,
+		Scope:    strings.Join(scopeStrings, " "),
+		ClientID: clientID.String(),
+		GrantID:  grantID.String(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "chirpy",
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
+			Subject:   userID.String(),
+		},
+	}
+	return k.sign(claim)
+}
+
+// token proving the password was right, to exchange for real tokens
+// together with a TOTP code
+func (k *Keyring) MakeChallengeJWT(userID uuid.UUID, expiresIn time.Duration) (string, error) {
+	claim := jwt.RegisteredClaims{
+		Issuer:    "chirpy",
+		Audience:  jwt.ClaimStrings{challengeAudience},
+		IssuedAt:  jwt.NewNumericDate(time.Now()),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
+		Subject:   userID.String(),
+	}
+	return k.sign(claim)
+}
+
+func (k *Keyring) ValidateChallengeJWT(tokenString string) (uuid.UUID, error) {
+	claimStruct := jwt.RegisteredClaims{}
+	if err := k.parse(tokenString, &claimStruct, jwt.WithAudience(challengeAudience)); err != nil {
+		return uuid.UUID{}, err
+	}
+
+	return uuid.Parse(claimStruct.Subject)
+}
+
+// what a magic link token says
+type MagicLinkClaims struct {
+	ID     uuid.UUID // "jti", to use the link only once
+	UserID uuid.UUID
+	Email  string // the link only works while the account still has this email
+}
+
+type magicLinkTokenClaims struct {
+	Email string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+// token for a passwordless login link sent to email
+func (k *Keyring) MakeMagicLinkJWT(claims MagicLinkClaims, expiresIn time.Duration) (string, error) {
+	claim := magicLinkTokenClaims{
+		Email: claims.Email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    "chirpy",
+			Audience:  jwt.ClaimStrings{magicLinkAudience},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn)),
+			Subject:   claims.UserID.String(),
+			ID:        claims.ID.String(),
+		},
+	}
+	return k.sign(claim)
+}
+
+// NOTE: only checks the signature and expiry, the caller must make sure
+// the link wasn't used before
+func (k *Keyring) ValidateMagicLinkJWT(tokenString string) (MagicLinkClaims, error) {
+	claimStruct := magicLinkTokenClaims{}
+	if err := k.parse(tokenString, &claimStruct, jwt.WithAudience(magicLinkAudience)); err != nil {
+		return MagicLinkClaims{}, err
+	}
+
+	userID, err := uuid.Parse(claimStruct.Subject)
+	if err != nil {
+		return MagicLinkClaims{}, err
+	}
+	linkID, err := uuid.Parse(claimStruct.ID)
+	if err != nil {
+		return MagicLinkClaims{}, err
+	}
+	if claimStruct.Email == "" {
+		return MagicLinkClaims{}, fmt.Errorf("magic link has no email")
+	}
+
+	return MagicLinkClaims{ID: linkID, UserID: userID, Email: claimStruct.Email}, nil
+}
+
+// sign with the active key and put its "kid" in the header
+func (k *Keyring) sign(claims jwt.Claims) (string, error) {
+	key := k.ActiveKey()
+	if key == nil {
+		return "", fmt.Errorf("no active signing key")
+	}
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.id
+
+	return token.SignedString(key.signKey)
+}
+
+// parse into claims, picking the key from the "kid" header.
+// tokens without "kid" (issued before keyring existed) use the active key.
+// the "alg" header must match the key, so nobody can e.g. sign HS256
+// with our RSA public key as the secret.
+func (k *Keyring) parse(tokenString string, claims jwt.Claims, opts ...jwt.ParserOption) error {
+	keyFunc := func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+
+		k.mu.RLock()
+		defer k.mu.RUnlock()
+
+		if kid == "" {
+			kid = k.activeKID
+		}
+		key, ok := k.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		if t.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %s for key %q", t.Method.Alg(), kid)
+		}
+		if k.expired(key, time.Now()) {
+			return nil, fmt.Errorf("signing key %q is past its grace period", kid)
+		}
+		return key.verifyKey, nil
+	}
+
+	validMethods := jwt.WithValidMethods([]string{
+		jwt.SigningMethodHS256.Alg(),
+		jwt.SigningMethodRS256.Alg(),
+		jwt.SigningMethodEdDSA.Alg(),
+	})
+	_, err := jwt.ParseWithClaims(tokenString, claims, keyFunc, append(opts, validMethods)...)
+	return err
+}
+
+// caller must hold the lock
+func (k *Keyring) checkNewKey(key *SigningKey) error {
+	if key == nil {
+		return fmt.Errorf("key is nil")
+	}
+	if key.id == "" {
+		return fmt.Errorf("key id is empty")
+	}
+	if _, ok := k.keys[key.id]; ok {
+		return fmt.Errorf("key id %q already in keyring", key.id)
+	}
+	return nil
+}
+
+func (k *Keyring) expired(key *SigningKey, now time.Time) bool {
+	return !key.retiredAt.IsZero() && now.After(key.retiredAt.Add(k.grace))
+}
+
+// random id for keys made by rotation
+func MakeKeyID() (string, error) {
+	id, err := MakeRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	return id[:16], nil
+}
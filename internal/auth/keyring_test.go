@@ -0,0 +1,334 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+func mustHMACKey(t *testing.T, kid, secret string) *SigningKey {
+	key, err := NewHMACKey(kid, []byte(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestKeyringRotation(t *testing.T) {
+	keyring := NewKeyring(time.Minute)
+	if _, err := keyring.Rotate(mustHMACKey(t, "old", "ernfgo23ldkfjsdg")); err != nil {
+		t.Fatal(err)
+	}
+
+	userID := uuid.New()
+	oldToken, _ := keyring.MakeJWT(userID, RoleUser, time.Minute*5)
+
+	retired, err := keyring.Rotate(mustHMACKey(t, "new", "qpwoeir2309sdflk"))
+	if err != nil {
+		t.Fatal(err)
+	} else if retired != "old" {
+		t.Errorf("retired key should be old, got %q", retired)
+	}
+
+	// old token still valid in grace period
+	claims, err := keyring.ValidateJWT(oldToken)
+	if err != nil {
+		t.Errorf("old token should validate in grace period: %s", err)
+	} else if claims.UserID != userID {
+		t.Errorf("uuid not match: %s vs %s", claims.UserID, userID)
+	}
+
+	// new token signed by new key
+	newToken, _ := keyring.MakeJWT(userID, RoleUser, time.Minute*5)
+	if _, err := keyring.ValidateJWT(newToken); err != nil {
+		t.Errorf("new token should validate: %s", err)
+	}
+
+	// duplicate kid
+	if _, err := keyring.Rotate(mustHMACKey(t, "new", "asdfasdf")); err == nil {
+		t.Errorf("duplicate kid should fail")
+	}
+}
+
+func TestKeyringGraceExpiration(t *testing.T) {
+	keyring := NewKeyring(time.Millisecond * 100)
+	keyring.Rotate(mustHMACKey(t, "old", "ernfgo23ldkfjsdg"))
+	oldToken, _ := keyring.MakeJWT(uuid.New(), RoleUser, time.Minute*5)
+	keyring.Rotate(mustHMACKey(t, "new", "qpwoeir2309sdflk"))
+
+	time.Sleep(time.Millisecond * 200)
+	if _, err := keyring.ValidateJWT(oldToken); err == nil {
+		t.Errorf("old token should fail after grace period")
+	}
+}
+
+func TestKeyringUnknownKey(t *testing.T) {
+	keyringA := NewKeyring(time.Minute)
+	keyringA.Rotate(mustHMACKey(t, "a", "ernfgo23ldkfjsdg"))
+	keyringB := NewKeyring(time.Minute)
+	keyringB.Rotate(mustHMACKey(t, "b", "ernfgo23ldkfjsdg"))
+
+	token, _ := keyringA.MakeJWT(uuid.New(), RoleUser, time.Minute*5)
+	if _, err := keyringB.ValidateJWT(token); err == nil {
+		t.Errorf("token with unknown kid should fail")
+	}
+}
+
+func TestKeyringAsymmetric(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	testCases := []struct {
+		kid        string
+		privateKey interface{}
+		alg        string
+		kty        string
+	}{
+		{"rsa", rsaKey, "RS256", "RSA"},
+		{"ed", edKey, "EdDSA", "OKP"},
+	}
+
+	for _, testCase := range testCases {
+		der, err := x509.MarshalPKCS8PrivateKey(testCase.privateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pemData := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+		key, err := NewPEMKey(testCase.kid, pemData)
+		if err != nil {
+			t.Fatalf("%s: %s", testCase.kid, err)
+		} else if key.Algorithm() != testCase.alg {
+			t.Errorf("%s: alg should be %s, got %s", testCase.kid, testCase.alg, key.Algorithm())
+		}
+
+		keyring := NewKeyring(time.Minute)
+		keyring.Rotate(key)
+
+		userID := uuid.New()
+		token, _ := keyring.MakeJWT(userID, RoleUser, time.Minute*5)
+		claims, err := keyring.ValidateJWT(token)
+		if err != nil {
+			t.Errorf("%s: %s", testCase.kid, err)
+		} else if claims.UserID != userID {
+			t.Errorf("%s: uuid not match: %s vs %s", testCase.kid, claims.UserID, userID)
+		}
+
+		jwks := keyring.JWKS()
+		if len(jwks.Keys) != 1 || jwks.Keys[0].Kid != testCase.kid || jwks.Keys[0].Kty != testCase.kty {
+			t.Errorf("%s: unexpected jwks %+v", testCase.kid, jwks)
+		}
+	}
+}
+
+// a generated key has to survive a restart through its PEM
+func TestGeneratedKeyPEMRoundTrip(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	for _, privateKey := range []interface{}{rsaKey, edKey} {
+		like, err := newPrivateKey("old", privateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		generated, err := GenerateKeyLike("new", like)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pemData, err := generated.PrivateKeyPEM()
+		if err != nil {
+			t.Fatalf("%s: %s", like.Algorithm(), err)
+		}
+		reloaded, err := NewPEMKey("new", pemData)
+		if err != nil {
+			t.Fatalf("%s: %s", like.Algorithm(), err)
+		}
+
+		// signed before the "restart", validated after it
+		before := NewKeyring(time.Minute)
+		before.Rotate(generated)
+		after := NewKeyring(time.Minute)
+		after.Rotate(reloaded)
+
+		userID := uuid.New()
+		token, _ := before.MakeJWT(userID, RoleUser, time.Minute*5)
+		if claims, err := after.ValidateJWT(token); err != nil || claims.UserID != userID {
+			t.Errorf("%s: token from the generated key should validate with the reloaded one: %v", like.Algorithm(), err)
+		}
+	}
+
+	hmacKey, _ := NewHMACKey("hs", []byte("secret"))
+	if _, err := hmacKey.PrivateKeyPEM(); err == nil {
+		t.Error("an HS256 key shouldn't have a PEM")
+	}
+}
+
+// sign HS256 using the public key bytes as secret, the classic alg confusion trick
+func TestKeyringRejectsAlgorithmMismatch(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	key, err := newPrivateKey("ed", edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := NewKeyring(time.Minute)
+	keyring.Rotate(key)
+
+	claim := jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		Subject:   uuid.New().String(),
+	}
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claim)
+	forged.Header["kid"] = "ed"
+	forgedString, _ := forged.SignedString([]byte(edKey.Public().(ed25519.PublicKey)))
+
+	if _, err := keyring.ValidateJWT(forgedString); err == nil {
+		t.Errorf("HS256 token for EdDSA key should fail")
+	}
+}
+
+func TestJWKSHidesHMAC(t *testing.T) {
+	keyring := NewKeyring(time.Minute)
+	keyring.Rotate(mustHMACKey(t, "hmac", "ernfgo23ldkfjsdg"))
+	if jwks := keyring.JWKS(); len(jwks.Keys) != 0 {
+		t.Errorf("hmac key should not be published: %+v", jwks)
+	}
+}
+
+func TestChallengeTokenIsNotAccessToken(t *testing.T) {
+	keyring := NewKeyring(time.Minute)
+	keyring.Rotate(mustHMACKey(t, "a", "ernfgo23ldkfjsdg"))
+
+	userID := uuid.New()
+	challenge, _ := keyring.MakeChallengeJWT(userID, time.Minute)
+	if _, err := keyring.ValidateJWT(challenge); err == nil {
+		t.Errorf("challenge token shouldn't work as access token")
+	}
+
+	id, err := keyring.ValidateChallengeJWT(challenge)
+	if err != nil {
+		t.Errorf("challenge token should validate: %s", err)
+	} else if id != userID {
+		t.Errorf("uuid not match: %s vs %s", id, userID)
+	}
+
+	access, _ := keyring.MakeJWT(userID, RoleUser, time.Minute)
+	if _, err := keyring.ValidateChallengeJWT(access); err == nil {
+		t.Errorf("access token shouldn't work as challenge token")
+	}
+}
+
+func TestMagicLinkToken(t *testing.T) {
+	keyring := NewKeyring(time.Minute)
+	keyring.Rotate(mustHMACKey(t, "a", "ernfgo23ldkfjsdg"))
+
+	link := MagicLinkClaims{ID: uuid.New(), UserID: uuid.New(), Email: "user@example.com"}
+	token, err := keyring.MakeMagicLinkJWT(link, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := keyring.ValidateMagicLinkJWT(token)
+	if err != nil {
+		t.Fatal(err)
+	} else if claims != link {
+		t.Errorf("claims should be %+v, got %+v", link, claims)
+	}
+
+	if _, err := keyring.ValidateJWT(token); err == nil {
+		t.Errorf("magic link token shouldn't work as access token")
+	}
+	if _, err := keyring.ValidateChallengeJWT(token); err == nil {
+		t.Errorf("magic link token shouldn't work as challenge token")
+	}
+
+	access, _ := keyring.MakeJWT(link.UserID, RoleUser, time.Minute)
+	if _, err := keyring.ValidateMagicLinkJWT(access); err == nil {
+		t.Errorf("access token shouldn't work as magic link token")
+	}
+
+	expired, _ := keyring.MakeMagicLinkJWT(link, -time.Minute)
+	if _, err := keyring.ValidateMagicLinkJWT(expired); err == nil {
+		t.Errorf("expired magic link should fail")
+	}
+}
+
+func TestOAuthToken(t *testing.T) {
+	keyring := NewKeyring(time.Minute)
+	keyring.Rotate(mustHMACKey(t, "a", "ernfgo23ldkfjsdg"))
+
+	userID, clientID, grantID := uuid.New(), uuid.New(), uuid.New()
+	token, err := keyring.MakeOAuthJWT(userID, clientID, grantID, []Scope{ScopeChirpsRead}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := keyring.ValidateJWT(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.UserID != userID || claims.ClientID != clientID || claims.GrantID != grantID || claims.Role != RoleUser {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+	if !claims.HasScope(ScopeChirpsRead) || claims.HasScope(ScopeChirpsWrite) {
+		t.Errorf("token should only have chirps:read, got %v", claims.Scopes)
+	}
+	if claims.ExpiresAt.Before(time.Now()) || claims.ExpiresAt.Sub(claims.IssuedAt) != time.Minute {
+		t.Errorf("unexpected lifetime %s - %s", claims.IssuedAt, claims.ExpiresAt)
+	}
+
+	// no scopes granted = nothing allowed, not everything
+	empty, _ := keyring.MakeOAuthJWT(userID, clientID, grantID, nil, time.Minute)
+	claims, err = keyring.ValidateJWT(empty)
+	if err != nil {
+		t.Fatal(err)
+	} else if claims.HasScope(ScopeChirpsRead) {
+		t.Errorf("token without scopes shouldn't have any")
+	}
+
+	login, _ := keyring.MakeJWT(userID, RoleUser, time.Minute)
+	claims, _ = keyring.ValidateJWT(login)
+	if claims.Scopes != nil || claims.GrantID != uuid.Nil {
+		t.Errorf("login token shouldn't look like an OAuth token: %+v", claims)
+	}
+}
+
+func TestRoleClaim(t *testing.T) {
+	keyring := NewKeyring(time.Minute)
+	keyring.Rotate(mustHMACKey(t, "a", "ernfgo23ldkfjsdg"))
+
+	token, _ := keyring.MakeJWT(uuid.New(), RoleModerator, time.Minute)
+	claims, err := keyring.ValidateJWT(token)
+	if err != nil {
+		t.Fatal(err)
+	} else if claims.Role != RoleModerator {
+		t.Errorf("role should be moderator, got %q", claims.Role)
+	}
+}
+
+func TestRoleAllows(t *testing.T) {
+	testCases := []struct {
+		role     Role
+		required Role
+		allowed  bool
+	}{
+		{RoleAdmin, RoleModerator, true},
+		{RoleAdmin, RoleAdmin, true},
+		{RoleModerator, RoleUser, true},
+		{RoleModerator, RoleAdmin, false},
+		{RoleUser, RoleModerator, false},
+		{Role("superuser"), RoleUser, false},
+	}
+	for i, testCase := range testCases {
+		if testCase.role.Allows(testCase.required) != testCase.allowed {
+			t.Errorf("test case %d: %s allows %s should be %v", i+1, testCase.role, testCase.required, testCase.allowed)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+// Argon2Params tune argon2id, see RFC 9106
+type Argon2Params struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// PasswordHasher hashes new passwords with one algorithm, and checks hashes
+// of every algorithm we ever used. Hashes carry their algorithm and params
+// ("$2a$10$..." for bcrypt, "$argon2id$v=19$m=...,t=...,p=...$salt$key"),
+// so changing the policy never breaks existing hashes.
+type PasswordHasher struct {
+	Algorithm  string // AlgorithmArgon2id or AlgorithmBcrypt
+	BcryptCost int
+	Argon2     Argon2Params
+
+	dummyOnce sync.Once
+	dummyHash string
+}
+
+// OWASP minimum for argon2id: 19 MiB, 2 iterations, 1 lane
+var DefaultArgon2Params = Argon2Params{
+	Memory:      19 * 1024,
+	Iterations:  2,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func NewPasswordHasher(algorithm string, bcryptCost int, argon2Params Argon2Params) (*PasswordHasher, error) {
+	switch algorithm {
+	case AlgorithmArgon2id:
+		if argon2Params.Memory < 8*uint32(argon2Params.Parallelism) || argon2Params.Iterations < 1 || argon2Params.Parallelism < 1 {
+			return nil, fmt.Errorf("invalid argon2id params %+v", argon2Params)
+		}
+		if argon2Params.SaltLength < 8 || argon2Params.KeyLength < 16 {
+			return nil, fmt.Errorf("argon2id salt or key too short")
+		}
+	case AlgorithmBcrypt:
+		if bcryptCost < bcrypt.MinCost || bcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("bcrypt cost %d out of range", bcryptCost)
+		}
+	default:
+		return nil, fmt.Errorf("unknown password hash algorithm %q", algorithm)
+	}
+
+	return &PasswordHasher{
+		Algorithm:  algorithm,
+		BcryptCost: bcryptCost,
+		Argon2:     argon2Params,
+	}, nil
+}
+
+// what HashPassword uses
+var DefaultPasswordHasher = &PasswordHasher{
+	Algorithm:  AlgorithmArgon2id,
+	BcryptCost: 10,
+	Argon2:     DefaultArgon2Params,
+}
+
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	if h.Algorithm == AlgorithmBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), h.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	}
+
+	p := h.Argon2
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// true if hash isn't what this hasher would make today (other algorithm,
+// weaker cost or different params), rehash it when you have the password
+func (h *PasswordHasher) NeedsRehash(hash string) bool {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		if h.Algorithm != AlgorithmArgon2id {
+			return true
+		}
+		p, salt, key, err := decodeArgon2Hash(hash)
+		if err != nil {
+			return false // can't verify it anyway
+		}
+		return p.Memory != h.Argon2.Memory || p.Iterations != h.Argon2.Iterations ||
+			p.Parallelism != h.Argon2.Parallelism ||
+			uint32(len(salt)) != h.Argon2.SaltLength || uint32(len(key)) != h.Argon2.KeyLength
+	}
+
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return false
+	}
+	return h.Algorithm != AlgorithmBcrypt || cost < h.BcryptCost
+}
+
+// takes about as long as checking a real hash but always fails. Use it when
+// there is no user, so response time doesn't tell which emails have an account
+func (h *PasswordHasher) CheckDummy(password string) error {
+	h.dummyOnce.Do(func() {
+		h.dummyHash, _ = h.Hash("chirpy dummy password")
+	})
+	CheckPasswordHash(password, h.dummyHash)
+	return bcrypt.ErrMismatchedHashAndPassword
+}
+
+// CheckPasswordHash for logins: an account without a usable hash (e.g. "unset"
+// on OIDC-only accounts) would fail instantly, so it gets the dummy work
+// instead, and costs the same as an unknown email or a current hash.
+// NOTE: legacy bcrypt hashes still take bcrypt time, not argon2id time, so until
+// all of them are rehashed on login, timing can tell those accounts apart
+func (h *PasswordHasher) Check(password, hash string) error {
+	if !strings.HasPrefix(hash, "$argon2id$") {
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return h.CheckDummy(password)
+		}
+	}
+	return CheckPasswordHash(password, hash)
+}
+
+// "$argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>" -> params, salt, key
+func decodeArgon2Hash(hash string) (Argon2Params, []byte, []byte, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return Argon2Params{}, nil, nil, fmt.Errorf("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Argon2Params{}, nil, nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+
+	p := Argon2Params{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Iterations, &p.Parallelism); err != nil {
+		return Argon2Params{}, nil, nil, fmt.Errorf("invalid argon2id params: %w", err)
+	}
+	if p.Iterations < 1 || p.Parallelism < 1 {
+		return Argon2Params{}, nil, nil, fmt.Errorf("invalid argon2id params")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2Params{}, nil, nil, err
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return Argon2Params{}, nil, nil, fmt.Errorf("invalid argon2id key")
+	}
+	p.SaltLength = uint32(len(salt))
+	p.KeyLength = uint32(len(key))
+
+	return p, salt, key, nil
+}
+
+func checkArgon2Hash(password, hash string) error {
+	p, salt, key, err := decodeArgon2Hash(hash)
+	if err != nil {
+		return err
+	}
+
+	other := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return bcrypt.ErrMismatchedHashAndPassword
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// cheap params, tests don't need real strength
+var testArgon2Params = Argon2Params{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+func TestArgon2idHash(t *testing.T) {
+	hasher, err := NewPasswordHasher(AlgorithmArgon2id, 10, testArgon2Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := hasher.Hash("abcdefg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("unexpected hash format: %s", hash)
+	}
+
+	if err := CheckPasswordHash("abcdefg", hash); err != nil {
+		t.Errorf("password should match: %s", err)
+	}
+	if err := CheckPasswordHash("abcdefh", hash); err == nil {
+		t.Errorf("wrong password shouldn't match")
+	}
+
+	other, _ := hasher.Hash("abcdefg")
+	if hash == other {
+		t.Errorf("hashes should be salted")
+	}
+}
+
+func TestCheckWithoutHash(t *testing.T) {
+	hasher, _ := NewPasswordHasher(AlgorithmArgon2id, 10, testArgon2Params)
+
+	for _, hash := range []string{"unset", ""} {
+		if err := hasher.Check("unset", hash); err == nil {
+			t.Errorf("%q shouldn't match anything", hash)
+		}
+	}
+	// the dummy hash was made and checked, same work as an unknown email
+	if hasher.dummyHash == "" {
+		t.Errorf("an account without a hash should do the dummy check")
+	}
+
+	hash, _ := hasher.Hash("abcdefg")
+	if err := hasher.Check("abcdefg", hash); err != nil {
+		t.Errorf("password should match: %s", err)
+	}
+}
+
+func TestCheckBcryptHash(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("abcdefg"), bcrypt.MinCost)
+	if err := CheckPasswordHash("abcdefg", string(hash)); err != nil {
+		t.Errorf("old bcrypt hash should still match: %s", err)
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	argonHasher, _ := NewPasswordHasher(AlgorithmArgon2id, 10, testArgon2Params)
+	stronger := testArgon2Params
+	stronger.Iterations = 2
+	strongerHasher, _ := NewPasswordHasher(AlgorithmArgon2id, 10, stronger)
+	bcryptHasher, _ := NewPasswordHasher(AlgorithmBcrypt, 5, testArgon2Params)
+	bcrypt6Hasher, _ := NewPasswordHasher(AlgorithmBcrypt, 6, testArgon2Params)
+
+	argonHash, _ := argonHasher.Hash("abcdefg")
+	bcryptHash, _ := bcryptHasher.Hash("abcdefg")
+
+	testCases := []struct {
+		hasher *PasswordHasher
+		hash   string
+		want   bool
+	}{
+		{argonHasher, argonHash, false},
+		{strongerHasher, argonHash, true},
+		{argonHasher, bcryptHash, true},
+		{bcryptHasher, bcryptHash, false},
+		{bcrypt6Hasher, bcryptHash, true},
+		{bcryptHasher, argonHash, true},
+		{argonHasher, "garbage", false},
+	}
+	for i, testCase := range testCases {
+		if got := testCase.hasher.NeedsRehash(testCase.hash); got != testCase.want {
+			t.Errorf("test case %d: NeedsRehash should be %v", i+1, testCase.want)
+		}
+	}
+}
+
+func TestInvalidArgon2Hash(t *testing.T) {
+	testCases := []string{
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA",
+		"$argon2id$v=18$m=64,t=1,p=1$c2FsdHNhbHQ$a2V5",
+		"$argon2id$v=19$m=64,t=0,p=1$c2FsdHNhbHQ$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=1$!!!$a2V5",
+	}
+	for i, hash := range testCases {
+		if err := CheckPasswordHash("abcdefg", hash); err == nil {
+			t.Errorf("test case %d: invalid hash shouldn't match", i+1)
+		}
+	}
+}
+
+func TestNewPasswordHasher(t *testing.T) {
+	if _, err := NewPasswordHasher("md5", 10, testArgon2Params); err == nil {
+		t.Errorf("unknown algorithm should fail")
+	}
+	if _, err := NewPasswordHasher(AlgorithmBcrypt, 40, testArgon2Params); err == nil {
+		t.Errorf("bcrypt cost 40 should fail")
+	}
+	if _, err := NewPasswordHasher(AlgorithmArgon2id, 10, Argon2Params{}); err == nil {
+		t.Errorf("empty argon2 params should fail")
+	}
+}
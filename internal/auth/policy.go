@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// PasswordPolicy decides which new passwords are acceptable.
+// it only runs when a password is set (signup, update, reset),
+// existing passwords keep working.
+type PasswordPolicy struct {
+	MinLength int                // in characters, not bytes
+	MaxLength int                // 0 = no limit. hashing a huge password is a cheap DoS
+	Breached  *BreachedPasswords // nil = no breached check
+}
+
+// one reason a password was rejected, for the client to show
+type PasswordViolation struct {
+	Code    string `json:"code"` // "too_short", "too_long" or "breached"
+	Message string `json:"message"`
+}
+
+// every reason the password is rejected, nil if it's fine
+func (p *PasswordPolicy) Check(password string) []PasswordViolation {
+	var violations []PasswordViolation
+
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		violations = append(violations, PasswordViolation{
+			Code:    "too_short",
+			Message: fmt.Sprintf("password must be at least %d characters", p.MinLength),
+		})
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		violations = append(violations, PasswordViolation{
+			Code:    "too_long",
+			Message: fmt.Sprintf("password must be at most %d characters", p.MaxLength),
+		})
+	}
+
+	// an empty password is "breached" too, but too_short already says enough
+	if length > 0 && p.Breached != nil && p.Breached.Contains(password) {
+		violations = append(violations, PasswordViolation{
+			Code:    "breached",
+			Message: "password appears in a list of breached passwords, pick another one",
+		})
+	}
+
+	return violations
+}
+
+// BreachedPasswords is a set of SHA-1 hashes of known breached passwords,
+// sorted in memory so a lookup is a binary search.
+type BreachedPasswords struct {
+	hashes [][sha1.Size]byte
+}
+
+// file has one uppercase or lowercase SHA-1 hex per line, in any order.
+// "HASH:count" lines (Have I Been Pwned downloads) work too, count is ignored.
+// empty lines and lines starting with # are skipped.
+func LoadBreachedPasswords(path string) (*BreachedPasswords, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	breached := &BreachedPasswords{}
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line, _, _ = strings.Cut(line, ":")
+
+		var hash [sha1.Size]byte
+		decoded, err := hex.DecodeString(line)
+		if err != nil || len(decoded) != sha1.Size {
+			return nil, fmt.Errorf("%s line %d: not a SHA-1 hex", path, lineNumber)
+		}
+		copy(hash[:], decoded)
+		breached.hashes = append(breached.hashes, hash)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(breached.hashes, func(i int, j int) bool {
+		return bytes.Compare(breached.hashes[i][:], breached.hashes[j][:]) < 0
+	})
+
+	return breached, nil
+}
+
+func (b *BreachedPasswords) Contains(password string) bool {
+	hash := sha1.Sum([]byte(password))
+	i := sort.Search(len(b.hashes), func(i int) bool {
+		return bytes.Compare(b.hashes[i][:], hash[:]) >= 0
+	})
+	return i < len(b.hashes) && b.hashes[i] == hash
+}
+
+func (b *BreachedPasswords) Len() int {
+	return len(b.hashes)
+}
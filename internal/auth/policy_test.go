@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeBreachedFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBreachedPasswords(t *testing.T) {
+	// sha1 of "password", "123456789" (with a count) and "qwerty", not sorted
+	path := writeBreachedFile(t, `# top passwords
+5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+F7C3BC1D808E04732ADF679965CCC34CA7AE3441:7016669
+
+b1b3773a05c0ed0176787a4f1574ff0075f7521e
+`)
+	breached, err := LoadBreachedPasswords(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if breached.Len() != 3 {
+		t.Errorf("should load 3 hashes, got %d", breached.Len())
+	}
+
+	for _, password := range []string{"password", "123456789", "qwerty"} {
+		if !breached.Contains(password) {
+			t.Errorf("%s should be breached", password)
+		}
+	}
+	for _, password := range []string{"Password", "correct horse battery staple", ""} {
+		if breached.Contains(password) {
+			t.Errorf("%q shouldn't be breached", password)
+		}
+	}
+}
+
+func TestBreachedPasswordsInvalidFile(t *testing.T) {
+	path := writeBreachedFile(t, "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8\npassword\n")
+	if _, err := LoadBreachedPasswords(path); err == nil {
+		t.Errorf("plain text line should fail")
+	}
+}
+
+func TestPasswordPolicy(t *testing.T) {
+	breached, err := LoadBreachedPasswords(writeBreachedFile(t, "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := PasswordPolicy{MinLength: 8, MaxLength: 16, Breached: breached}
+
+	testCases := []struct {
+		password string
+		codes    []string
+	}{
+		{"", []string{"too_short"}},
+		{"abc", []string{"too_short"}},
+		{"password", []string{"breached"}},
+		{"ünïcödé", []string{"too_short"}}, // 7 characters, 11 bytes
+		{"ünïcödé!", nil},
+		{"a much too long password", []string{"too_long"}},
+		{"correct horse", nil},
+	}
+
+	for i, testCase := range testCases {
+		violations := policy.Check(testCase.password)
+		if len(violations) != len(testCase.codes) {
+			t.Errorf("test case %d: want %v, got %+v", i+1, testCase.codes, violations)
+			continue
+		}
+		for j, violation := range violations {
+			if violation.Code != testCase.codes[j] {
+				t.Errorf("test case %d: want %v, got %+v", i+1, testCase.codes, violations)
+			}
+		}
+	}
+}
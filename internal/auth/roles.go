@@ -0,0 +1,37 @@
+package auth
+
+import "fmt"
+
+// Role of a user, stored in users.role and carried in the access token
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator" // can delete any chirp
+	RoleAdmin     Role = "admin"     // can do everything, /admin/* routes
+)
+
+// higher rank can do everything a lower one can
+var roleRank = map[Role]int{
+	RoleUser:      0,
+	RoleModerator: 1,
+	RoleAdmin:     2,
+}
+
+func ParseRole(s string) (Role, error) {
+	role := Role(s)
+	if _, ok := roleRank[role]; !ok {
+		return "", fmt.Errorf("unknown role %q", s)
+	}
+	return role, nil
+}
+
+// true if r is at least required (admin allows moderator, etc.)
+// unknown roles allow nothing
+func (r Role) Allows(required Role) bool {
+	rank, ok := roleRank[r]
+	if !ok {
+		return false
+	}
+	return rank >= roleRank[required]
+}
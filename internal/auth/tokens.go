@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Scope limits what a personal access token or a third-party app can do.
+// access tokens from login (JWTs) have no scopes, they can do everything.
+type Scope string
+
+const (
+	ScopeChirpsRead  Scope = "chirps:read"
+	ScopeChirpsWrite Scope = "chirps:write" // post and delete chirps
+)
+
+var knownScopes = map[Scope]bool{
+	ScopeChirpsRead:  true,
+	ScopeChirpsWrite: true,
+}
+
+// personal access tokens start with this, so we can tell them from JWTs
+// without parsing (and secret scanners can spot leaked ones)
+const PersonalAccessTokenPrefix = "chirpy_pat_"
+
+// random token with the prefix, only its HashToken goes to db
+func MakePersonalAccessToken() (string, error) {
+	token, err := MakeRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	return PersonalAccessTokenPrefix + token, nil
+}
+
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, PersonalAccessTokenPrefix)
+}
+
+// check every scope is known, drop duplicates
+func ParseScopes(scopes []string) ([]Scope, error) {
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("at least one scope is needed")
+	}
+
+	parsed := []Scope{}
+	seen := map[Scope]bool{}
+	for _, s := range scopes {
+		scope := Scope(s)
+		if !knownScopes[scope] {
+			return nil, fmt.Errorf("unknown scope %q", s)
+		}
+		if seen[scope] {
+			continue
+		}
+		seen[scope] = true
+		parsed = append(parsed, scope)
+	}
+	return parsed, nil
+}
+
+// login tokens (no scopes) can do everything, personal access and OAuth tokens only their scopes
+func (c AccessClaims) HasScope(scope Scope) bool {
+	if c.Scopes == nil {
+		return true
+	}
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
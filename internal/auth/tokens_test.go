@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestMakePersonalAccessToken(t *testing.T) {
+	token, err := MakePersonalAccessToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsPersonalAccessToken(token) {
+		t.Errorf("token should have the prefix: %s", token)
+	}
+
+	other, _ := MakePersonalAccessToken()
+	if token == other {
+		t.Errorf("tokens should be random")
+	}
+
+	jwtToken, _ := MakeJWT(uuid.New(), "secret", time.Minute)
+	if IsPersonalAccessToken(jwtToken) {
+		t.Errorf("jwt is not a personal access token")
+	}
+}
+
+func TestParseScopes(t *testing.T) {
+	testCases := []struct {
+		scopes  []string
+		want    int
+		wantErr bool
+	}{
+		{[]string{"chirps:read"}, 1, false},
+		{[]string{"chirps:write", "chirps:read"}, 2, false},
+		{[]string{"chirps:write", "chirps:write"}, 1, false},
+		{[]string{}, 0, true},
+		{[]string{"chirps:read", "admin"}, 0, true},
+	}
+
+	for i, testCase := range testCases {
+		scopes, err := ParseScopes(testCase.scopes)
+		if (err != nil) != testCase.wantErr {
+			t.Errorf("test case %d: unexpected error %v", i+1, err)
+		} else if len(scopes) != testCase.want {
+			t.Errorf("test case %d: want %d scopes, got %v", i+1, testCase.want, scopes)
+		}
+	}
+}
+
+func TestHasScope(t *testing.T) {
+	login := AccessClaims{UserID: uuid.New(), Role: RoleUser}
+	if !login.HasScope(ScopeChirpsWrite) {
+		t.Errorf("login token should have every scope")
+	}
+
+	pat := AccessClaims{UserID: uuid.New(), Role: RoleUser, Scopes: []Scope{ScopeChirpsRead}}
+	if !pat.HasScope(ScopeChirpsRead) {
+		t.Errorf("token should have chirps:read")
+	}
+	if pat.HasScope(ScopeChirpsWrite) {
+		t.Errorf("token shouldn't have chirps:write")
+	}
+}
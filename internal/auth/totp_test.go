@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// test vectors from RFC 6238 appendix B (SHA1, last 6 digits)
+func TestTOTPCode(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	testCases := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for i, testCase := range testCases {
+		code, err := TOTPCode(secret, TOTPStep(time.Unix(testCase.unix, 0)))
+		if err != nil {
+			t.Errorf("test case %d: %s", i+1, err)
+		} else if code != testCase.code {
+			t.Errorf("test case %d: want %s, got %s", i+1, testCase.code, code)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, _ := GenerateTOTPSecret()
+	now := time.Now()
+
+	code, _ := TOTPCode(secret, TOTPStep(now))
+	step, err := ValidateTOTP(code, secret, now)
+	if err != nil {
+		t.Errorf("current code should validate: %s", err)
+	} else if step != TOTPStep(now) {
+		t.Errorf("matched step should be current step")
+	}
+
+	// one step of clock drift is fine
+	if _, err := ValidateTOTP(code, secret, now.Add(time.Second*30)); err != nil {
+		t.Errorf("code from previous step should validate: %s", err)
+	}
+
+	// five minutes later it's not
+	if _, err := ValidateTOTP(code, secret, now.Add(time.Minute*5)); err == nil {
+		t.Errorf("old code shouldn't validate")
+	}
+
+	if _, err := ValidateTOTP("12345", secret, now); err == nil {
+		t.Errorf("short code shouldn't validate")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := MakeRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 10 {
+		t.Fatalf("want 10 codes, got %d", len(codes))
+	}
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if seen[code] {
+			t.Errorf("duplicate code %s", code)
+		}
+		seen[code] = true
+
+		if NormalizeRecoveryCode(code) != code {
+			t.Errorf("normalizing %s should do nothing", code)
+		}
+	}
+
+	messy := " " + codes[0][0:4] + " " + codes[0][5:9] + codes[0][10:]
+	if NormalizeRecoveryCode(messy) != codes[0] {
+		t.Errorf("%q should normalize to %s", messy, codes[0])
+	}
+}
@@ -7,28 +7,79 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
+const countReplies = `-- name: CountReplies :many
+SELECT in_reply_to, COUNT(*) AS replies FROM chirps
+WHERE in_reply_to = ANY($1::uuid[]) AND publish_at <= NOW() AND deleted_at IS NULL
+GROUP BY in_reply_to
+`
+
+type CountRepliesRow struct {
+	InReplyTo uuid.NullUUID
+	Replies   int64
+}
+
+// direct replies that anyone can see, per chirp of a page
+func (q *Queries) CountReplies(ctx context.Context, ids []uuid.UUID) ([]CountRepliesRow, error) {
+	rows, err := q.db.QueryContext(ctx, countReplies, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CountRepliesRow
+	for rows.Next() {
+		var i CountRepliesRow
+		if err := rows.Scan(
+			&i.InReplyTo,
+			&i.Replies,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const createChirp = `-- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, publish_at, in_reply_to)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1,
-    $2
-) RETURNING id, created_at, updated_at, body, user_id
+    $2::uuid,
+    COALESCE($3, NOW()),
+    $4
+) RETURNING id, created_at, updated_at, body, user_id, publish_at, in_reply_to, deleted_at
 `
 
 type CreateChirpParams struct {
-	Body   string
-	UserID uuid.UUID
+	Body      string
+	UserID    uuid.UUID
+	PublishAt sql.NullTime
+	InReplyTo uuid.NullUUID
 }
 
+// publish_at NULL = right now
 func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, createChirp, arg.Body, arg.UserID)
+	row := q.db.QueryRowContext(ctx, createChirp,
+		arg.Body,
+		arg.UserID,
+		arg.PublishAt,
+		arg.InReplyTo,
+	)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -36,6 +87,9 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.PublishAt,
+		&i.InReplyTo,
+		&i.DeletedAt,
 	)
 	return i, err
 }
@@ -51,10 +105,12 @@ func (q *Queries) DeleteChirpByID(ctx context.Context, id uuid.UUID) error {
 }
 
 const getAllChirps = `-- name: GetAllChirps :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps
-ORDER BY created_at ASC
+SELECT id, created_at, updated_at, body, user_id, publish_at, in_reply_to, deleted_at FROM chirps
+WHERE publish_at <= NOW() AND deleted_at IS NULL
+ORDER BY publish_at ASC
 `
 
+// published only, no tombstones
 func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
 	rows, err := q.db.QueryContext(ctx, getAllChirps)
 	if err != nil {
@@ -70,6 +126,60 @@ func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.PublishAt,
+			&i.InReplyTo,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getChirpAncestors = `-- name: GetChirpAncestors :many
+WITH RECURSIVE ancestors AS (
+    SELECT parent.id, parent.created_at, parent.updated_at, parent.body, parent.user_id, parent.publish_at, parent.in_reply_to, parent.deleted_at, 1 AS depth FROM chirps parent
+    WHERE parent.id = (SELECT in_reply_to FROM chirps WHERE chirps.id = $1)
+    UNION ALL
+    SELECT parent.id, parent.created_at, parent.updated_at, parent.body, parent.user_id, parent.publish_at, parent.in_reply_to, parent.deleted_at, ancestors.depth + 1 FROM chirps parent
+    JOIN ancestors ON parent.id = ancestors.in_reply_to
+    WHERE ancestors.depth < $2::int
+)
+SELECT id, created_at, updated_at, body, user_id, publish_at, in_reply_to, deleted_at FROM ancestors
+ORDER BY depth DESC
+`
+
+type GetChirpAncestorsParams struct {
+	ID       uuid.UUID
+	MaxDepth int32
+}
+
+// the chirps above id in its thread, up to max_depth of them, the root (or the farthest one) first
+func (q *Queries) GetChirpAncestors(ctx context.Context, arg GetChirpAncestorsParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpAncestors, arg.ID, arg.MaxDepth)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.PublishAt,
+			&i.InReplyTo,
+			&i.DeletedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -85,10 +195,11 @@ func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
 }
 
 const getChirpByAuthorID = `-- name: GetChirpByAuthorID :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps
-WHERE user_id = $1
+SELECT id, created_at, updated_at, body, user_id, publish_at, in_reply_to, deleted_at FROM chirps
+WHERE user_id = $1::uuid AND publish_at <= NOW() AND deleted_at IS NULL
 `
 
+// published only, no tombstones
 func (q *Queries) GetChirpByAuthorID(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
 	rows, err := q.db.QueryContext(ctx, getChirpByAuthorID, userID)
 	if err != nil {
@@ -104,6 +215,9 @@ func (q *Queries) GetChirpByAuthorID(ctx context.Context, userID uuid.UUID) ([]C
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.PublishAt,
+			&i.InReplyTo,
+			&i.DeletedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -119,10 +233,11 @@ func (q *Queries) GetChirpByAuthorID(ctx context.Context, userID uuid.UUID) ([]C
 }
 
 const getChirpByID = `-- name: GetChirpByID :one
-SELECT id, created_at, updated_at, body, user_id FROM chirps
+SELECT id, created_at, updated_at, body, user_id, publish_at, in_reply_to, deleted_at FROM chirps
 WHERE id = $1
 `
 
+// scheduled ones and tombstones too, callers check publish_at and deleted_at
 func (q *Queries) GetChirpByID(ctx context.Context, id uuid.UUID) (Chirp, error) {
 	row := q.db.QueryRowContext(ctx, getChirpByID, id)
 	var i Chirp
@@ -132,10 +247,211 @@ func (q *Queries) GetChirpByID(ctx context.Context, id uuid.UUID) (Chirp, error)
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.PublishAt,
+		&i.InReplyTo,
+		&i.DeletedAt,
+	)
+	return i, err
+}
+
+const getChirpReplies = `-- name: GetChirpReplies :many
+WITH RECURSIVE tree AS (
+    SELECT top.id, top.created_at, top.updated_at, top.body, top.user_id, top.publish_at, top.in_reply_to, top.deleted_at, 1 AS depth FROM (
+        SELECT id, created_at, updated_at, body, user_id, publish_at, in_reply_to, deleted_at FROM chirps
+        WHERE chirps.in_reply_to = $1 AND chirps.publish_at <= NOW()
+            AND ($2::timestamp IS NULL
+                OR (chirps.publish_at, chirps.id) > ($2::timestamp, $3::uuid))
+        ORDER BY chirps.publish_at, chirps.id
+        LIMIT $4
+    ) top
+    UNION ALL
+    SELECT reply.id, reply.created_at, reply.updated_at, reply.body, reply.user_id, reply.publish_at, reply.in_reply_to, reply.deleted_at, tree.depth + 1 FROM tree
+    CROSS JOIN LATERAL (
+        SELECT id, created_at, updated_at, body, user_id, publish_at, in_reply_to, deleted_at FROM chirps
+        WHERE chirps.in_reply_to = tree.id AND chirps.publish_at <= NOW()
+        ORDER BY chirps.publish_at, chirps.id
+        LIMIT $6::int
+    ) reply
+    WHERE tree.depth < $5::int
+)
+SELECT id, created_at, updated_at, body, user_id, publish_at, in_reply_to, deleted_at, depth FROM (
+    SELECT id, created_at, updated_at, body, user_id, publish_at, in_reply_to, deleted_at, depth FROM tree LIMIT $7::int
+) capped
+ORDER BY depth, publish_at, id
+`
+
+type GetChirpRepliesParams struct {
+	ParentID       uuid.NullUUID
+	AfterPublishAt sql.NullTime
+	AfterID        uuid.NullUUID
+	MaxResults     int32
+	MaxDepth       int32
+	MaxChildren    int32
+	MaxTotal       int32
+}
+
+type GetChirpRepliesRow struct {
+	ID        uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Body      string
+	UserID    uuid.NullUUID
+	PublishAt time.Time
+	InReplyTo uuid.NullUUID
+	DeletedAt sql.NullTime
+	Depth     int32
+}
+
+// a page of direct replies to parent_id (oldest first, after the cursor), each
+// with its replies down to max_depth levels, the first max_children of them per
+// chirp (the rest are in reply_count, and in that chirp's own thread), and
+// max_total in all: the recursion goes a level at a time and stops there, so
+// the deepest levels are the ones cut. published ones only, tombstones too
+func (q *Queries) GetChirpReplies(ctx context.Context, arg GetChirpRepliesParams) ([]GetChirpRepliesRow, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpReplies,
+		arg.ParentID,
+		arg.AfterPublishAt,
+		arg.AfterID,
+		arg.MaxResults,
+		arg.MaxDepth,
+		arg.MaxChildren,
+		arg.MaxTotal,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetChirpRepliesRow
+	for rows.Next() {
+		var i GetChirpRepliesRow
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.PublishAt,
+			&i.InReplyTo,
+			&i.DeletedAt,
+			&i.Depth,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const hasReplies = `-- name: HasReplies :one
+SELECT EXISTS (SELECT 1 FROM chirps WHERE in_reply_to = $1)
+`
+
+// any reply, scheduled and tombstones too
+func (q *Queries) HasReplies(ctx context.Context, inReplyTo uuid.NullUUID) (bool, error) {
+	row := q.db.QueryRowContext(ctx, hasReplies, inReplyTo)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const listScheduledChirps = `-- name: ListScheduledChirps :many
+SELECT id, created_at, updated_at, body, user_id, publish_at, in_reply_to, deleted_at FROM chirps
+WHERE user_id = $1::uuid AND publish_at > NOW() AND deleted_at IS NULL
+ORDER BY publish_at ASC
+`
+
+func (q *Queries) ListScheduledChirps(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listScheduledChirps, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.PublishAt,
+			&i.InReplyTo,
+			&i.DeletedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lockChirp = `-- name: LockChirp :one
+SELECT id, created_at, updated_at, body, user_id, publish_at, in_reply_to, deleted_at FROM chirps
+WHERE id = $1
+FOR UPDATE
+`
+
+// GetChirpByID that holds the row until the tx ends. new replies wait for it too
+// (their foreign key check locks the parent), so HasReplies stays true
+func (q *Queries) LockChirp(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, lockChirp, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.PublishAt,
+		&i.InReplyTo,
+		&i.DeletedAt,
 	)
 	return i, err
 }
 
+const lockUserChirps = `-- name: LockUserChirps :many
+SELECT id FROM chirps
+WHERE user_id = $1::uuid
+FOR UPDATE
+`
+
+// LockChirp for all chirps of a user, before deleting the account
+func (q *Queries) LockUserChirps(ctx context.Context, userID uuid.UUID) ([]uuid.UUID, error) {
+	rows, err := q.db.QueryContext(ctx, lockUserChirps, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []uuid.UUID
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		items = append(items, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const resetChirp = `-- name: ResetChirp :exec
 DELETE FROM chirps
 `
@@ -144,3 +460,57 @@ func (q *Queries) ResetChirp(ctx context.Context) error {
 	_, err := q.db.ExecContext(ctx, resetChirp)
 	return err
 }
+
+const tombstoneChirp = `-- name: TombstoneChirp :exec
+UPDATE chirps
+SET body = '', deleted_at = NOW(), updated_at = NOW()
+WHERE id = $1
+`
+
+// delete a chirp that has replies, what's left only holds the thread together
+func (q *Queries) TombstoneChirp(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, tombstoneChirp, id)
+	return err
+}
+
+const tombstoneUserChirps = `-- name: TombstoneUserChirps :exec
+UPDATE chirps
+SET body = '', user_id = NULL, deleted_at = COALESCE(deleted_at, NOW()), updated_at = NOW()
+WHERE user_id = $1::uuid
+    AND EXISTS (SELECT 1 FROM chirps reply WHERE reply.in_reply_to = chirps.id)
+`
+
+// before deleting an account: its chirps with replies stay as tombstones
+// without an author, so the cascade leaves them alone
+func (q *Queries) TombstoneUserChirps(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, tombstoneUserChirps, userID)
+	return err
+}
+
+const updateChirpBody = `-- name: UpdateChirpBody :one
+UPDATE chirps
+SET body = $1, updated_at = NOW()
+WHERE id = $2
+RETURNING id, created_at, updated_at, body, user_id, publish_at, in_reply_to, deleted_at
+`
+
+type UpdateChirpBodyParams struct {
+	Body string
+	ID   uuid.UUID
+}
+
+func (q *Queries) UpdateChirpBody(ctx context.Context, arg UpdateChirpBodyParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, updateChirpBody, arg.Body, arg.ID)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.PublishAt,
+		&i.InReplyTo,
+		&i.DeletedAt,
+	)
+	return i, err
+}
@@ -0,0 +1,131 @@
+// Package entitlements says what a user may do, depending on their plan.
+// handlers ask here instead of hardcoding limits.
+package entitlements
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+type Plan string
+
+const (
+	PlanFree Plan = "free"
+	PlanRed  Plan = "red" // Chirpy Red members
+)
+
+// Limits of one plan
+type Limits struct {
+	MaxChirpLength int
+	ChirpsPerHour  int           // 0 = no limit
+	EditWindow     time.Duration // how long after publishing a chirp can be edited, 0 = never
+	ScheduleAhead  time.Duration // how far ahead a chirp can be scheduled, 0 = no scheduling
+}
+
+func (l Limits) CanEdit() bool {
+	return l.EditWindow > 0
+}
+
+func (l Limits) CanSchedule() bool {
+	return l.ScheduleAhead > 0
+}
+
+// Entitlements has the limits of every plan
+type Entitlements struct {
+	Free Limits
+	Red  Limits
+}
+
+// Default is what Chirpy gives without configuration:
+// free users keep the classic 140 characters
+func Default() Entitlements {
+	return Entitlements{
+		Free: Limits{
+			MaxChirpLength: 140,
+			ChirpsPerHour:  30,
+		},
+		Red: Limits{
+			MaxChirpLength: 280,
+			ChirpsPerHour:  300,
+			EditWindow:     time.Hour,
+			ScheduleAhead:  time.Hour * 24 * 30,
+		},
+	}
+}
+
+func PlanOf(isChirpyRed bool) Plan {
+	if isChirpyRed {
+		return PlanRed
+	}
+	return PlanFree
+}
+
+func (e Entitlements) For(plan Plan) Limits {
+	if plan == PlanRed {
+		return e.Red
+	}
+	return e.Free
+}
+
+// FromEnv starts from Default and overrides what getenv has, per plan:
+// <PLAN>_MAX_CHIRP_LENGTH, <PLAN>_CHIRPS_PER_HOUR, <PLAN>_EDIT_WINDOW, <PLAN>_SCHEDULE_AHEAD
+// with PLAN = FREE or RED, e.g. RED_MAX_CHIRP_LENGTH=500, FREE_EDIT_WINDOW=5m
+func FromEnv(getenv func(string) string) (Entitlements, error) {
+	if getenv == nil {
+		getenv = os.Getenv
+	}
+
+	e := Default()
+	if err := limitsFromEnv(getenv, "FREE", &e.Free); err != nil {
+		return Entitlements{}, err
+	}
+	if err := limitsFromEnv(getenv, "RED", &e.Red); err != nil {
+		return Entitlements{}, err
+	}
+	return e, nil
+}
+
+func limitsFromEnv(getenv func(string) string, prefix string, limits *Limits) error {
+	ints := []struct {
+		name string
+		dst  *int
+		min  int
+	}{
+		{prefix + "_MAX_CHIRP_LENGTH", &limits.MaxChirpLength, 1},
+		{prefix + "_CHIRPS_PER_HOUR", &limits.ChirpsPerHour, 0},
+	}
+	for _, v := range ints {
+		value := getenv(v.name)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < v.min {
+			return fmt.Errorf("invalid %s: %q", v.name, value)
+		}
+		*v.dst = parsed
+	}
+
+	durations := []struct {
+		name string
+		dst  *time.Duration
+	}{
+		{prefix + "_EDIT_WINDOW", &limits.EditWindow},
+		{prefix + "_SCHEDULE_AHEAD", &limits.ScheduleAhead},
+	}
+	for _, v := range durations {
+		value := getenv(v.name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed < 0 {
+			return fmt.Errorf("invalid %s: %q", v.name, value)
+		}
+		*v.dst = parsed
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package entitlements
+
+import (
+	"testing"
+	"time"
+)
+
+func envOf(values map[string]string) func(string) string {
+	return func(name string) string {
+		return values[name]
+	}
+}
+
+func TestDefault(t *testing.T) {
+	e := Default()
+
+	free := e.For(PlanOf(false))
+	if free.MaxChirpLength != 140 || free.CanEdit() || free.CanSchedule() {
+		t.Errorf("unexpected free limits %+v", free)
+	}
+
+	red := e.For(PlanOf(true))
+	if red.MaxChirpLength <= free.MaxChirpLength || !red.CanEdit() || !red.CanSchedule() {
+		t.Errorf("red should get more than free, got %+v", red)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	e, err := FromEnv(envOf(map[string]string{
+		"RED_MAX_CHIRP_LENGTH": "500",
+		"FREE_EDIT_WINDOW":     "5m",
+		"RED_SCHEDULE_AHEAD":   "0s",
+		"FREE_CHIRPS_PER_HOUR": "0",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if e.Red.MaxChirpLength != 500 || e.Red.CanSchedule() {
+		t.Errorf("unexpected red limits %+v", e.Red)
+	}
+	if e.Free.EditWindow != time.Minute*5 || e.Free.ChirpsPerHour != 0 {
+		t.Errorf("unexpected free limits %+v", e.Free)
+	}
+	// untouched values keep their default
+	if e.Free.MaxChirpLength != 140 || e.Red.EditWindow != Default().Red.EditWindow {
+		t.Errorf("defaults should stay, got %+v", e)
+	}
+}
+
+func TestFromEnvInvalid(t *testing.T) {
+	for _, env := range []map[string]string{
+		{"FREE_MAX_CHIRP_LENGTH": "0"},
+		{"RED_MAX_CHIRP_LENGTH": "many"},
+		{"RED_CHIRPS_PER_HOUR": "-1"},
+		{"RED_EDIT_WINDOW": "-1m"},
+		{"FREE_SCHEDULE_AHEAD": "tomorrow"},
+	} {
+		if _, err := FromEnv(envOf(env)); err == nil {
+			t.Errorf("%v should be refused", env)
+		}
+	}
+}
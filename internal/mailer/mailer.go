@@ -0,0 +1,113 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string // plain text
+}
+
+// Mailer sends emails. Handlers only know this interface, so dev and tests
+// can use LogMailer instead of a real mail server.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// no CR/LF in headers, otherwise someone could inject their own headers
+func (msg Message) validate() error {
+	if msg.To == "" {
+		return fmt.Errorf("message has no recipient")
+	}
+	if strings.ContainsAny(msg.To, "\r\n") || strings.ContainsAny(msg.Subject, "\r\n") {
+		return fmt.Errorf("message header contains a newline")
+	}
+	return nil
+}
+
+// SMTPMailer sends through an SMTP server.
+// net/smtp upgrades to STARTTLS when the server supports it, and refuses
+// to send the password over a plain connection (except to localhost).
+type SMTPMailer struct {
+	addr         string
+	auth         smtp.Auth // nil = no auth
+	from         string    // "From" header, can have a display name
+	envelopeFrom string    // bare address for the SMTP "MAIL FROM"
+}
+
+// from can be "no-reply@example.com" or "Chirpy <no-reply@example.com>"
+func NewSMTPMailer(host, port, username, password, from string) (*SMTPMailer, error) {
+	fromAddress, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from address: %w", err)
+	}
+
+	mailer := &SMTPMailer{
+		addr:         net.JoinHostPort(host, port),
+		from:         fromAddress.String(),
+		envelopeFrom: fromAddress.Address,
+	}
+	if username != "" {
+		mailer.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return mailer, nil
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+
+	data := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\n"+
+		"MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s",
+		m.from, msg.To, msg.Subject, time.Now().Format(time.RFC1123Z),
+		strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	// smtp.SendMail doesn't take a context, so at least don't wait for it
+	// after the caller gave up
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(m.addr, m.auth, m.envelopeFrom, []string{msg.To}, []byte(data))
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// LogMailer writes emails to a writer (stdout, a file...) instead of sending them.
+// for dev and tests, you can copy the link from the output.
+type LogMailer struct {
+	mu  sync.Mutex // one message at a time, so they don't interleave
+	out io.Writer
+}
+
+func NewLogMailer(out io.Writer) *LogMailer {
+	return &LogMailer{out: out}
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := fmt.Fprintf(m.out, "---- mail %s ----\nTo: %s\nSubject: %s\n\n%s\n---- end mail ----\n",
+		time.Now().Format(time.RFC3339), msg.To, msg.Subject, msg.Body)
+	return err
+}
@@ -0,0 +1,38 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestLogMailer(t *testing.T) {
+	var out bytes.Buffer
+	mailer := NewLogMailer(&out)
+
+	msg := Message{To: "ron@example.com", Subject: "hi", Body: "token: abc123"}
+	if err := mailer.Send(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"To: ron@example.com", "Subject: hi", "token: abc123"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output should contain %q, got %q", want, out.String())
+		}
+	}
+}
+
+func TestHeaderInjection(t *testing.T) {
+	mailer := NewLogMailer(&bytes.Buffer{})
+	testCases := []Message{
+		{To: "ron@example.com\r\nBcc: everyone@example.com", Subject: "hi"},
+		{To: "ron@example.com", Subject: "hi\nBcc: everyone@example.com"},
+		{To: "", Subject: "hi"},
+	}
+	for i, msg := range testCases {
+		if err := mailer.Send(context.Background(), msg); err == nil {
+			t.Errorf("test case %d should fail", i+1)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+// Package oidc is a small OpenID Connect client for the authorization code
+// flow with PKCE: discovery, the redirect URL, code exchange and ID token checks.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type Config struct {
+	Name         string // our name for it, the {provider} in /api/oauth/{provider}/...
+	Issuer       string
+	ClientID     string
+	ClientSecret string // empty for public clients, PKCE alone then
+	RedirectURL  string
+	Scopes       []string // "openid" is always added
+}
+
+// Provider is one OIDC issuer. The discovery document is fetched on first
+// use and cached, so a provider that is down at startup doesn't stop us.
+type Provider struct {
+	config Config
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *discovery
+	keys      *keySet
+}
+
+// the parts of /.well-known/openid-configuration we use
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Claims of a verified ID token
+type Claims struct {
+	Subject       string // stable id of the user at the issuer
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// CanLinkByEmail says if a login with claims may be added to the existing
+// account with the same email. both sides must have verified it: the provider,
+// or anyone could sign up there with our users' emails, and us, or anyone
+// could sign up here with someone's email first and wait for them to log in
+// with the provider, then share the account (and still have its password)
+func CanLinkByEmail(claims Claims, accountEmailVerified bool) bool {
+	return claims.EmailVerified && accountEmailVerified
+}
+
+func NewProvider(config Config, client *http.Client) (*Provider, error) {
+	if config.Name == "" || config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+		return nil, fmt.Errorf("oidc provider %q needs an issuer, client id and redirect url", config.Name)
+	}
+	if client == nil {
+		client = &http.Client{Timeout: time.Second * 10}
+	}
+	return &Provider{config: config, client: client}, nil
+}
+
+func (p *Provider) Name() string {
+	return p.config.Name
+}
+
+// random url-safe string for state, nonce and the PKCE verifier
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// S256 code challenge of a PKCE verifier (RFC 7636)
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// where to send the user to log in at the issuer
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	scopes := []string{"openid"}
+	for _, scope := range p.config.Scopes {
+		if scope != "openid" {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", p.config.ClientID)
+	query.Set("redirect_uri", p.config.RedirectURL)
+	query.Set("scope", strings.Join(scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", nonce)
+	query.Set("code_challenge", CodeChallenge(verifier))
+	query.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return d.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// trade the code from the callback for an ID token, and verify it
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (Claims, error) {
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("code_verifier", verifier)
+	form.Set("client_id", p.config.ClientID)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Claims{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		// client_secret_basic, values are form-encoded first (RFC 6749 2.3.1)
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return Claims{}, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return Claims{}, err
+	}
+	if res.StatusCode != 200 {
+		return Claims{}, fmt.Errorf("token endpoint: %s: %s", res.Status, body)
+	}
+
+	var tokenRes struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokenRes); err != nil {
+		return Claims{}, err
+	}
+	if tokenRes.IDToken == "" {
+		return Claims{}, fmt.Errorf("token endpoint returned no id_token")
+	}
+
+	return p.VerifyIDToken(ctx, tokenRes.IDToken, nonce)
+}
+
+type idTokenClaims struct {
+	Nonce         string `json:"nonce"`
+	Email         string `json:"email"`
+	EmailVerified any    `json:"email_verified"` // some issuers send "true"
+	Name          string `json:"name"`
+	AuthorizedBy  string `json:"azp"`
+	jwt.RegisteredClaims
+}
+
+// check signature (key from the issuer's JWKS), issuer, audience, expiry and nonce
+func (p *Provider) VerifyIDToken(ctx context.Context, rawToken, nonce string) (Claims, error) {
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	keyFunc := func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.getKey(ctx, d.JWKSURI, kid, t.Method.Alg())
+	}
+
+	claims := idTokenClaims{}
+	_, err = jwt.ParseWithClaims(rawToken, &claims, keyFunc,
+		jwt.WithValidMethods([]string{"RS256", "ES256", "EdDSA"}),
+		jwt.WithIssuer(d.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return Claims{}, fmt.Errorf("invalid id token: %w", err)
+	}
+
+	if nonce == "" || claims.Nonce != nonce {
+		return Claims{}, fmt.Errorf("id token nonce doesn't match")
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedBy != p.config.ClientID {
+		return Claims{}, fmt.Errorf("id token azp doesn't match")
+	}
+	if claims.Subject == "" {
+		return Claims{}, fmt.Errorf("id token has no subject")
+	}
+
+	verified := false
+	switch v := claims.EmailVerified.(type) {
+	case bool:
+		verified = v
+	case string:
+		verified = v == "true"
+	}
+
+	return Claims{
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: verified,
+		Name:          claims.Name,
+	}, nil
+}
+
+func (p *Provider) getDiscovery(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	d := &discovery{}
+	wellKnown := strings.TrimSuffix(p.config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, wellKnown, d); err != nil {
+		return nil, fmt.Errorf("oidc discovery for %s: %w", p.config.Name, err)
+	}
+
+	// the document must be about the issuer we asked for (OIDC Discovery 4.3)
+	if d.Issuer != p.config.Issuer {
+		return nil, fmt.Errorf("oidc discovery for %s: issuer %q doesn't match %q", p.config.Name, d.Issuer, p.config.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc discovery for %s: missing endpoints", p.config.Name)
+	}
+
+	p.discovery = d
+	return d, nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return fmt.Errorf("GET %s: %s", url, res.Status)
+	}
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(v)
+}
@@ -0,0 +1,222 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockIssuer is a tiny OIDC issuer: discovery, JWKS, and a token endpoint
+// that hands out one ID token for one code, if the PKCE verifier matches
+type mockIssuer struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	clientID  string
+	code      string
+	challenge string // from the authorization request
+	claims    jwt.MapClaims
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIssuer{key: key, clientID: "chirpy", code: "the-code"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "mock",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != m.code || CodeChallenge(r.Form.Get("code_verifier")) != m.challenge {
+			w.WriteHeader(400)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": m.sign(t, m.claims)})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+
+	m.claims = jwt.MapClaims{
+		"iss":            m.server.URL,
+		"aud":            m.clientID,
+		"sub":            "user-123",
+		"email":          "user@example.com",
+		"email_verified": true,
+		"exp":            time.Now().Add(time.Minute).Unix(),
+		"iat":            time.Now().Unix(),
+	}
+	return m
+}
+
+func (m *mockIssuer) sign(t *testing.T, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "mock"
+	signed, err := token.SignedString(m.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func (m *mockIssuer) provider(t *testing.T) *Provider {
+	provider, err := NewProvider(Config{
+		Name:        "mock",
+		Issuer:      m.server.URL,
+		ClientID:    m.clientID,
+		RedirectURL: "http://localhost:8080/api/oauth/mock/callback",
+	}, m.server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+// start the flow like a browser would and remember the PKCE challenge
+func (m *mockIssuer) authorize(t *testing.T, provider *Provider, nonce, verifier string) {
+	authURL, err := provider.AuthCodeURL(context.Background(), "state", nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _ := url.Parse(authURL)
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("client_id") != m.clientID || query.Get("scope") != "openid" {
+		t.Errorf("unexpected authorization url %s", authURL)
+	}
+	m.challenge = query.Get("code_challenge")
+	m.claims["nonce"] = query.Get("nonce")
+}
+
+func TestExchange(t *testing.T) {
+	m := newMockIssuer(t)
+	provider := m.provider(t)
+	verifier, _ := RandomString()
+	m.authorize(t, provider, "nonce-1", verifier)
+
+	claims, err := provider.Exchange(context.Background(), m.code, verifier, "nonce-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Claims{Subject: "user-123", Email: "user@example.com", EmailVerified: true}
+	if claims != want {
+		t.Errorf("claims should be %+v, got %+v", want, claims)
+	}
+}
+
+func TestExchangeWrongVerifier(t *testing.T) {
+	m := newMockIssuer(t)
+	provider := m.provider(t)
+	verifier, _ := RandomString()
+	m.authorize(t, provider, "nonce-1", verifier)
+
+	other, _ := RandomString()
+	if _, err := provider.Exchange(context.Background(), m.code, other, "nonce-1"); err == nil {
+		t.Errorf("exchange with the wrong PKCE verifier should fail")
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	m := newMockIssuer(t)
+	provider := m.provider(t)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	testCases := []struct {
+		name   string
+		change func(claims jwt.MapClaims)
+		key    *rsa.PrivateKey
+	}{
+		{"wrong nonce", func(c jwt.MapClaims) { c["nonce"] = "other" }, nil},
+		{"wrong audience", func(c jwt.MapClaims) { c["aud"] = "someone-else" }, nil},
+		{"wrong issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }, nil},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, nil},
+		{"no expiry", func(c jwt.MapClaims) { delete(c, "exp") }, nil},
+		{"wrong azp", func(c jwt.MapClaims) { c["aud"] = []string{m.clientID, "other"}; c["azp"] = "other" }, nil},
+		{"wrong key", func(c jwt.MapClaims) {}, otherKey},
+	}
+
+	for _, testCase := range testCases {
+		claims := jwt.MapClaims{}
+		for k, v := range m.claims {
+			claims[k] = v
+		}
+		claims["nonce"] = "nonce-1"
+		testCase.change(claims)
+
+		var token string
+		if testCase.key != nil {
+			signed := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+			signed.Header["kid"] = "mock"
+			token, _ = signed.SignedString(testCase.key)
+		} else {
+			token = m.sign(t, claims)
+		}
+
+		if _, err := provider.VerifyIDToken(context.Background(), token, "nonce-1"); err == nil {
+			t.Errorf("%s: id token should be refused", testCase.name)
+		}
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	m := newMockIssuer(t)
+	provider, _ := NewProvider(Config{
+		Name:        "mock",
+		Issuer:      m.server.URL + "/", // same document, but its issuer has no slash
+		ClientID:    m.clientID,
+		RedirectURL: "http://localhost:8080/api/oauth/mock/callback",
+	}, m.server.Client())
+
+	if _, err := provider.AuthCodeURL(context.Background(), "state", "nonce", "verifier"); err == nil {
+		t.Errorf("discovery for another issuer should fail")
+	}
+}
+
+func TestCanLinkByEmail(t *testing.T) {
+	testCases := []struct {
+		name            string
+		providerChecked bool
+		accountChecked  bool
+		want            bool
+	}{
+		{"both verified", true, true, true},
+		{"unverified at the provider", false, true, false},
+		// someone signed up with the email here and never verified it
+		{"unverified account", true, false, false},
+		{"neither", false, false, false},
+	}
+
+	for _, testCase := range testCases {
+		claims := Claims{Subject: "sub", Email: "a@example.com", EmailVerified: testCase.providerChecked}
+		if got := CanLinkByEmail(claims, testCase.accountChecked); got != testCase.want {
+			t.Errorf("%s: CanLinkByEmail = %v, want %v", testCase.name, got, testCase.want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Package profile validates the public profile fields of a user:
+// the @handle, display name, bio, location and website.
+package profile
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	MinHandleLength      = 3
+	MaxHandleLength      = 30
+	MaxDisplayNameLength = 50
+	MaxBioLength         = 160
+	MaxLocationLength    = 30
+	MaxWebsiteLength     = 200
+)
+
+var (
+	ErrHandleFormat   = fmt.Errorf("handle must be %d-%d letters, digits or underscores, starting with a letter", MinHandleLength, MaxHandleLength)
+	ErrHandleReserved = errors.New("handle is reserved")
+)
+
+// handles that would look official, or clash with our own paths
+var reservedHandles = map[string]bool{
+	"about": true, "admin": true, "administrator": true, "api": true, "app": true,
+	"chirpy": true, "chirpy_red": true, "everyone": true, "help": true, "here": true,
+	"login": true, "logout": true, "me": true, "mod": true, "moderator": true,
+	"null": true, "oauth": true, "official": true, "root": true, "security": true,
+	"settings": true, "signup": true, "staff": true, "support": true, "system": true,
+	"undefined": true,
+}
+
+// NormalizeHandle drops a leading "@", "@Ron" and "Ron" are the same handle.
+// case is kept for display, uniqueness ignores it
+func NormalizeHandle(handle string) string {
+	return strings.TrimPrefix(strings.TrimSpace(handle), "@")
+}
+
+// ValidateHandle checks a normalized handle
+func ValidateHandle(handle string) error {
+	if len(handle) < MinHandleLength || len(handle) > MaxHandleLength {
+		return ErrHandleFormat
+	}
+	for i, c := range handle {
+		isLetter := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		isDigit := c >= '0' && c <= '9'
+		if i == 0 && !isLetter {
+			return ErrHandleFormat
+		}
+		if !isLetter && !isDigit && c != '_' {
+			return ErrHandleFormat
+		}
+	}
+
+	if reservedHandles[strings.ToLower(handle)] {
+		return ErrHandleReserved
+	}
+	return nil
+}
+
+// Fields are the free-form profile fields, empty = not set
+type Fields struct {
+	DisplayName string
+	Bio         string
+	Location    string
+	Website     string
+}
+
+// Validate returns the first problem, lengths are in characters
+func (f Fields) Validate() error {
+	limits := []struct {
+		name  string
+		value string
+		max   int
+	}{
+		{"display_name", f.DisplayName, MaxDisplayNameLength},
+		{"bio", f.Bio, MaxBioLength},
+		{"location", f.Location, MaxLocationLength},
+		{"website", f.Website, MaxWebsiteLength},
+	}
+	for _, limit := range limits {
+		if utf8.RuneCountInString(limit.value) > limit.max {
+			return fmt.Errorf("%s is longer than %d characters", limit.name, limit.max)
+		}
+		if strings.ContainsFunc(limit.value, isControl) {
+			return fmt.Errorf("%s has control characters", limit.name)
+		}
+	}
+
+	if f.Website != "" {
+		website, err := url.Parse(f.Website)
+		if err != nil || (website.Scheme != "https" && website.Scheme != "http") || website.Host == "" || website.User != nil {
+			return errors.New("website must be an http(s) URL")
+		}
+	}
+	return nil
+}
+
+// newlines are fine in a bio, the rest would mess up clients
+func isControl(c rune) bool {
+	return (c < 0x20 && c != '\n') || c == 0x7f
+}
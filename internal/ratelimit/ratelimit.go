@@ -0,0 +1,131 @@
+// Package ratelimit tracks failed attempts per key (an email, an IP...)
+// and tells when the next attempt is allowed.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Policy of one Limiter.
+// the first FreeAttempts failures cost nothing, after that every failure
+// doubles the wait, starting at BaseDelay, up to MaxDelay.
+// LockoutAfter failures in a row lock the key for LockoutDuration.
+// failures are forgotten after ForgetAfter without a new one.
+type Policy struct {
+	FreeAttempts    int
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	LockoutAfter    int // 0 = never lock out
+	LockoutDuration time.Duration
+	ForgetAfter     time.Duration
+}
+
+// Limiter is safe to use from many goroutines.
+// state lives in memory, so it's per instance and gone after a restart.
+type Limiter struct {
+	mu      sync.Mutex
+	policy  Policy
+	entries map[string]*entry
+	lastGC  time.Time
+}
+
+type entry struct {
+	failures    int
+	lastFailure time.Time
+	nextAllowed time.Time
+}
+
+func New(policy Policy) *Limiter {
+	return &Limiter{
+		policy:  policy,
+		entries: map[string]*entry{},
+	}
+}
+
+// how long key has to wait before trying again, 0 = go ahead
+func (l *Limiter) Wait(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	e, ok := l.entries[key]
+	if !ok || l.forgotten(e, now) {
+		return 0
+	}
+	if now.Before(e.nextAllowed) {
+		return e.nextAllowed.Sub(now)
+	}
+	return 0
+}
+
+// record a failed attempt, return how long key has to wait now
+func (l *Limiter) Fail(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.gc(now)
+
+	e, ok := l.entries[key]
+	if !ok || l.forgotten(e, now) {
+		e = &entry{}
+		l.entries[key] = e
+	}
+	e.failures++
+	e.lastFailure = now
+
+	wait := l.delay(e.failures)
+	e.nextAllowed = now.Add(wait)
+	return wait
+}
+
+// forget the key's failures, e.g. after a successful login
+func (l *Limiter) Reset(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, key)
+}
+
+// wait after this many failures in a row
+func (l *Limiter) delay(failures int) time.Duration {
+	p := l.policy
+	if p.LockoutAfter > 0 && failures >= p.LockoutAfter {
+		return p.LockoutDuration
+	}
+
+	extra := failures - p.FreeAttempts
+	if extra <= 0 {
+		return 0
+	}
+
+	wait := p.BaseDelay
+	for i := 1; i < extra && wait < p.MaxDelay; i++ {
+		wait *= 2
+	}
+	if p.MaxDelay > 0 && wait > p.MaxDelay {
+		wait = p.MaxDelay
+	}
+	return wait
+}
+
+// caller must hold the lock
+func (l *Limiter) forgotten(e *entry, now time.Time) bool {
+	if now.Before(e.nextAllowed) {
+		return false // never forget a key that is still waiting
+	}
+	return l.policy.ForgetAfter > 0 && now.Sub(e.lastFailure) > l.policy.ForgetAfter
+}
+
+// drop forgotten entries once in a while, so spraying random keys
+// doesn't grow the map forever. caller must hold the lock
+func (l *Limiter) gc(now time.Time) {
+	if l.policy.ForgetAfter <= 0 || now.Sub(l.lastGC) < l.policy.ForgetAfter {
+		return
+	}
+	l.lastGC = now
+
+	for key, e := range l.entries {
+		if l.forgotten(e, now) {
+			delete(l.entries, key)
+		}
+	}
+}
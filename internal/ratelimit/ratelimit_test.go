@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+var testPolicy = Policy{
+	FreeAttempts:    2,
+	BaseDelay:       time.Second,
+	MaxDelay:        time.Second * 4,
+	LockoutAfter:    8,
+	LockoutDuration: time.Hour,
+	ForgetAfter:     time.Hour * 2,
+}
+
+func TestBackoff(t *testing.T) {
+	limiter := New(testPolicy)
+	now := time.Now()
+
+	// free, free, 1s, 2s, 4s, 4s (max), 4s, lockout
+	want := []time.Duration{0, 0, time.Second, time.Second * 2, time.Second * 4, time.Second * 4, time.Second * 4, time.Hour}
+	for i, wantWait := range want {
+		wait := limiter.Fail("a", now)
+		if wait != wantWait {
+			t.Errorf("failure %d: wait should be %s, got %s", i+1, wantWait, wait)
+		}
+	}
+
+	if wait := limiter.Wait("a", now.Add(time.Minute)); wait != time.Hour-time.Minute {
+		t.Errorf("key should be locked out, wait %s", wait)
+	}
+	if wait := limiter.Wait("b", now); wait != 0 {
+		t.Errorf("other keys shouldn't wait, got %s", wait)
+	}
+}
+
+func TestWaitEnds(t *testing.T) {
+	limiter := New(testPolicy)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		limiter.Fail("a", now)
+	}
+	if wait := limiter.Wait("a", now); wait != time.Second {
+		t.Errorf("wait should be 1s, got %s", wait)
+	}
+	if wait := limiter.Wait("a", now.Add(time.Second)); wait != 0 {
+		t.Errorf("wait should be over, got %s", wait)
+	}
+}
+
+func TestResetAndForget(t *testing.T) {
+	limiter := New(testPolicy)
+	now := time.Now()
+
+	for i := 0; i < 5; i++ {
+		limiter.Fail("a", now)
+	}
+	limiter.Reset("a")
+	if wait := limiter.Fail("a", now); wait != 0 {
+		t.Errorf("failures should start over after reset, got %s", wait)
+	}
+
+	for i := 0; i < 5; i++ {
+		limiter.Fail("b", now)
+	}
+	later := now.Add(testPolicy.ForgetAfter + time.Minute)
+	if wait := limiter.Fail("b", later); wait != 0 {
+		t.Errorf("failures should be forgotten, got %s", wait)
+	}
+}
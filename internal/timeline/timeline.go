@@ -0,0 +1,189 @@
+// Package timeline builds home timelines: the chirps of a user and of whoever
+// they follow, newest first.
+//
+// two strategies, picked with TIMELINE_STRATEGY:
+//   - read (default): fan-out-on-read, every page is a join of follows and chirps.
+//     nothing to maintain, but the query gets slower the more people a user follows
+//   - write: fan-out-on-write, a posted chirp is copied to every follower's
+//     timeline_entries, so a page is one index scan. posting costs one row per follower
+package timeline
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WaronLimsakul/Chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+type Strategy string
+
+const (
+	FanOutOnRead  Strategy = "read"
+	FanOutOnWrite Strategy = "write"
+)
+
+// how many of an author's chirps a new follower gets with fan-out-on-write,
+// older ones are only on the author's own page
+const BackfillLimit = 200
+
+var ErrBadCursor = errors.New("invalid timeline cursor")
+
+// ParseStrategy reads TIMELINE_STRATEGY, "" = read
+func ParseStrategy(s string) (Strategy, error) {
+	switch Strategy(strings.ToLower(strings.TrimSpace(s))) {
+	case "", FanOutOnRead:
+		return FanOutOnRead, nil
+	case FanOutOnWrite:
+		return FanOutOnWrite, nil
+	}
+	return "", fmt.Errorf("unknown timeline strategy %q, want read or write", s)
+}
+
+// Cursor is where a page ends: the last chirp on it.
+// the zero Cursor is the first page
+type Cursor struct {
+	PublishAt time.Time
+	ChirpID   uuid.UUID
+}
+
+func (c Cursor) IsZero() bool {
+	return c.ChirpID == uuid.Nil
+}
+
+// String is opaque for clients, parse it back with ParseCursor
+func (c Cursor) String() string {
+	raw := strconv.FormatInt(c.PublishAt.UnixMicro(), 10) + "_" + c.ChirpID.String()
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func ParseCursor(s string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrBadCursor
+	}
+
+	micros, id, ok := strings.Cut(string(raw), "_")
+	if !ok {
+		return Cursor{}, ErrBadCursor
+	}
+
+	unixMicro, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return Cursor{}, ErrBadCursor
+	}
+
+	chirpID, err := uuid.Parse(id)
+	if err != nil || chirpID == uuid.Nil {
+		return Cursor{}, ErrBadCursor
+	}
+
+	// postgres TIMESTAMP keeps microseconds, in UTC like we write them
+	return Cursor{PublishAt: time.UnixMicro(unixMicro).UTC(), ChirpID: chirpID}, nil
+}
+
+// CursorOf is the cursor of the page ending with chirp
+func CursorOf(chirp database.Chirp) Cursor {
+	return Cursor{PublishAt: chirp.PublishAt, ChirpID: chirp.ID}
+}
+
+// Store is the part of *database.Queries a Timeline needs
+type Store interface {
+	ListTimelineByJoin(ctx context.Context, arg database.ListTimelineByJoinParams) ([]database.Chirp, error)
+	ListTimelineEntries(ctx context.Context, arg database.ListTimelineEntriesParams) ([]database.Chirp, error)
+	FanOutChirp(ctx context.Context, arg database.FanOutChirpParams) error
+	BackfillTimeline(ctx context.Context, arg database.BackfillTimelineParams) error
+	RemoveTimelineAuthor(ctx context.Context, arg database.RemoveTimelineAuthorParams) error
+	PruneTimelineEntries(ctx context.Context) (int64, error)
+	FillTimelineEntries(ctx context.Context) (int64, error)
+}
+
+// Timeline reads pages, and hears about what changes them.
+// call the hooks in the same transaction as the change (give New the tx's queries)
+type Timeline struct {
+	strategy Strategy
+	store    Store
+}
+
+// New is cheap, make one per request or transaction
+func New(strategy Strategy, store Store) Timeline {
+	return Timeline{strategy: strategy, store: store}
+}
+
+// Page is up to limit chirps of userID's timeline, older than before
+func (t Timeline) Page(ctx context.Context, userID uuid.UUID, before Cursor, limit int) ([]database.Chirp, error) {
+	params := database.ListTimelineByJoinParams{
+		UserID:     userID,
+		MaxResults: int32(limit),
+	}
+	if !before.IsZero() {
+		params.BeforePublishAt = sql.NullTime{Time: before.PublishAt, Valid: true}
+		params.BeforeID = uuid.NullUUID{UUID: before.ChirpID, Valid: true}
+	}
+
+	if t.strategy == FanOutOnWrite {
+		return t.store.ListTimelineEntries(ctx, database.ListTimelineEntriesParams(params))
+	}
+	return t.store.ListTimelineByJoin(ctx, params)
+}
+
+// ChirpPosted after a chirp is created
+func (t Timeline) ChirpPosted(ctx context.Context, chirp database.Chirp) error {
+	if t.strategy != FanOutOnWrite {
+		return nil
+	}
+	return t.store.FanOutChirp(ctx, database.FanOutChirpParams{
+		ChirpID:   chirp.ID,
+		AuthorID:  chirp.UserID.UUID,
+		PublishAt: chirp.PublishAt,
+	})
+}
+
+// Followed after followerID starts following followeeID
+func (t Timeline) Followed(ctx context.Context, followerID, followeeID uuid.UUID) error {
+	if t.strategy != FanOutOnWrite {
+		return nil
+	}
+	return t.store.BackfillTimeline(ctx, database.BackfillTimelineParams{
+		UserID:     followerID,
+		AuthorID:   followeeID,
+		MaxResults: BackfillLimit,
+	})
+}
+
+// Unfollowed after followerID stops following followeeID
+func (t Timeline) Unfollowed(ctx context.Context, followerID, followeeID uuid.UUID) error {
+	if t.strategy != FanOutOnWrite {
+		return nil
+	}
+	return t.store.RemoveTimelineAuthor(ctx, database.RemoveTimelineAuthorParams{
+		UserID:   followerID,
+		AuthorID: followeeID,
+	})
+}
+
+// Rebuild brings timeline_entries up to date with follows and chirps.
+// the hooks do nothing on fan-out-on-read, so run it when starting with
+// fan-out-on-write. safe to run again, returns how many rows were removed and added
+func (t Timeline) Rebuild(ctx context.Context) (removed, added int64, err error) {
+	if t.strategy != FanOutOnWrite {
+		return 0, 0, nil
+	}
+
+	removed, err = t.store.PruneTimelineEntries(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	added, err = t.store.FillTimelineEntries(ctx)
+	if err != nil {
+		return removed, 0, err
+	}
+	return removed, added, nil
+}
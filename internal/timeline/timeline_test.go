@@ -0,0 +1,138 @@
+package timeline
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/WaronLimsakul/Chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+type fakeStore struct {
+	calls  []string
+	params database.ListTimelineByJoinParams
+}
+
+func (s *fakeStore) ListTimelineByJoin(ctx context.Context, arg database.ListTimelineByJoinParams) ([]database.Chirp, error) {
+	s.calls = append(s.calls, "ListTimelineByJoin")
+	s.params = arg
+	return nil, nil
+}
+
+func (s *fakeStore) ListTimelineEntries(ctx context.Context, arg database.ListTimelineEntriesParams) ([]database.Chirp, error) {
+	s.calls = append(s.calls, "ListTimelineEntries")
+	s.params = database.ListTimelineByJoinParams(arg)
+	return nil, nil
+}
+
+func (s *fakeStore) FanOutChirp(ctx context.Context, arg database.FanOutChirpParams) error {
+	s.calls = append(s.calls, "FanOutChirp")
+	return nil
+}
+
+func (s *fakeStore) BackfillTimeline(ctx context.Context, arg database.BackfillTimelineParams) error {
+	s.calls = append(s.calls, "BackfillTimeline")
+	return nil
+}
+
+func (s *fakeStore) RemoveTimelineAuthor(ctx context.Context, arg database.RemoveTimelineAuthorParams) error {
+	s.calls = append(s.calls, "RemoveTimelineAuthor")
+	return nil
+}
+
+func (s *fakeStore) PruneTimelineEntries(ctx context.Context) (int64, error) {
+	s.calls = append(s.calls, "PruneTimelineEntries")
+	return 1, nil
+}
+
+func (s *fakeStore) FillTimelineEntries(ctx context.Context) (int64, error) {
+	s.calls = append(s.calls, "FillTimelineEntries")
+	return 2, nil
+}
+
+func TestParseStrategy(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Strategy
+		wantErr bool
+	}{
+		{"", FanOutOnRead, false},
+		{"read", FanOutOnRead, false},
+		{" Write ", FanOutOnWrite, false},
+		{"push", "", true},
+	}
+	for _, tt := range tests {
+		got, err := ParseStrategy(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseStrategy(%q) = %q, %v; want %q, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCursor(t *testing.T) {
+	c := Cursor{PublishAt: time.Date(2025, 3, 1, 12, 0, 0, 123456000, time.UTC), ChirpID: uuid.New()}
+	got, err := ParseCursor(c.String())
+	if err != nil {
+		t.Fatalf("ParseCursor: %v", err)
+	}
+	if !got.PublishAt.Equal(c.PublishAt) || got.ChirpID != c.ChirpID {
+		t.Errorf("round trip: got %+v, want %+v", got, c)
+	}
+
+	for _, bad := range []string{"", "!!", "bm90aGluZw", Cursor{}.String()} {
+		if _, err := ParseCursor(bad); err != ErrBadCursor {
+			t.Errorf("ParseCursor(%q) error = %v, want ErrBadCursor", bad, err)
+		}
+	}
+}
+
+func TestPage(t *testing.T) {
+	userID := uuid.New()
+	before := Cursor{PublishAt: time.Now().UTC(), ChirpID: uuid.New()}
+
+	store := &fakeStore{}
+	if _, err := New(FanOutOnRead, store).Page(context.Background(), userID, Cursor{}, 20); err != nil {
+		t.Fatal(err)
+	}
+	if store.calls[0] != "ListTimelineByJoin" || store.params.BeforePublishAt.Valid || store.params.MaxResults != 20 {
+		t.Errorf("first page on read: calls %v, params %+v", store.calls, store.params)
+	}
+
+	store = &fakeStore{}
+	if _, err := New(FanOutOnWrite, store).Page(context.Background(), userID, before, 20); err != nil {
+		t.Fatal(err)
+	}
+	if store.calls[0] != "ListTimelineEntries" || store.params.BeforeID.UUID != before.ChirpID || store.params.UserID != userID {
+		t.Errorf("next page on write: calls %v, params %+v", store.calls, store.params)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	ctx := context.Background()
+	chirp := database.Chirp{ID: uuid.New(), UserID: uuid.NullUUID{UUID: uuid.New(), Valid: true}, PublishAt: time.Now()}
+	run := func(tl Timeline) {
+		tl.ChirpPosted(ctx, chirp)
+		tl.Followed(ctx, uuid.New(), chirp.UserID.UUID)
+		tl.Unfollowed(ctx, uuid.New(), chirp.UserID.UUID)
+		tl.Rebuild(ctx)
+	}
+
+	store := &fakeStore{}
+	run(New(FanOutOnRead, store))
+	if len(store.calls) != 0 {
+		t.Errorf("fan-out-on-read should write nothing, got %v", store.calls)
+	}
+
+	store = &fakeStore{}
+	run(New(FanOutOnWrite, store))
+	want := []string{"FanOutChirp", "BackfillTimeline", "RemoveTimelineAuthor", "PruneTimelineEntries", "FillTimelineEntries"}
+	if len(store.calls) != len(want) {
+		t.Fatalf("calls = %v, want %v", store.calls, want)
+	}
+	for i := range want {
+		if store.calls[i] != want[i] {
+			t.Errorf("calls = %v, want %v", store.calls, want)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package webhook
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var (
+	oldSecret = []byte("old-secret")
+	newSecret = []byte("new-secret")
+	body      = []byte(`{"id":"evt_1","event":"user.upgraded"}`)
+)
+
+func TestVerify(t *testing.T) {
+	now := time.Now()
+	verifier := Verifier{Secrets: [][]byte{newSecret, oldSecret}, Tolerance: time.Minute * 5}
+
+	tests := []struct {
+		name   string
+		header string
+		body   []byte
+		want   error
+	}{
+		{"new secret", Sign(body, now, newSecret), body, nil},
+		{"old secret while rotating", Sign(body, now, oldSecret), body, nil},
+		{"both secrets", Sign(body, now, []byte("unknown"), newSecret), body, nil},
+		{"unknown secret", Sign(body, now, []byte("unknown")), body, ErrSignatureInvalid},
+		{"changed body", Sign(body, now, newSecret), []byte(`{"id":"evt_2"}`), ErrSignatureInvalid},
+		{"too old", Sign(body, now.Add(-time.Minute*6), newSecret), body, ErrTimestamp},
+		{"from the future", Sign(body, now.Add(time.Minute*6), newSecret), body, ErrTimestamp},
+		{"no signature", "t=123", body, ErrMalformed},
+		{"garbage", "nope", body, ErrMalformed},
+		{"empty", "", body, ErrMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifier.Verify(tt.header, tt.body, now)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("want %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestTimestampIsSigned(t *testing.T) {
+	now := time.Now()
+	verifier := Verifier{Secrets: [][]byte{newSecret}, Tolerance: time.Minute * 5}
+
+	// an old delivery with a fresh t shouldn't pass
+	old := Sign(body, now.Add(-time.Hour), newSecret)
+	fresh := Sign(body, now, newSecret)
+	forged := fresh[:len("t=")+10] + old[len("t=")+10:]
+	if err := verifier.Verify(forged, body, now); !errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("want %v, got %v", ErrSignatureInvalid, err)
+	}
+}
+
+func TestNoSecrets(t *testing.T) {
+	now := time.Now()
+	verifier := Verifier{Tolerance: time.Minute}
+	if err := verifier.Verify(Sign(body, now, newSecret), body, now); !errors.Is(err, ErrNoSecrets) {
+		t.Errorf("want %v, got %v", ErrNoSecrets, err)
+	}
+}
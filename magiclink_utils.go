@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/WaronLimsakul/Chirpy/internal/auth"
+	"github.com/WaronLimsakul/Chirpy/internal/database"
+	"github.com/WaronLimsakul/Chirpy/internal/mailer"
+	"github.com/google/uuid"
+)
+
+const magicLinkLifetime = time.Minute * 15
+
+// email a login link to "email" in body
+// - always 202 right away, the link and the mail are made in the background,
+// so the response time doesn't tell who has an account either
+// - a few mails per email, then throttled (see mailThrottled), still 202
+// - asking again kills the previous link
+func (cfg *apiConfig) sendMagicLink(w http.ResponseWriter, r *http.Request) {
+	type reqBodyStruct struct {
+		Email string `json:"email"`
+	}
+
+	var req reqBodyStruct
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	if !cfg.mailThrottled("magic-link", req.Email) {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+			defer cancel()
+			if err := cfg.mailMagicLink(ctx, req.Email); err != nil {
+				log.Printf("error sending magic link: %s", err)
+			}
+		}()
+	}
+
+	w.WriteHeader(202)
+}
+
+// the background part of sendMagicLink. no account = nothing to do
+func (cfg *apiConfig) mailMagicLink(ctx context.Context, email string) error {
+	user, err := cfg.dbQueries.GetUserByEmail(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if err := cfg.dbQueries.InvalidateUserMagicLinks(ctx, user.ID); err != nil {
+		return fmt.Errorf("invalidating magic links: %w", err)
+	}
+
+	link := auth.MagicLinkClaims{
+		ID:     uuid.New(),
+		UserID: user.ID,
+		Email:  user.Email,
+	}
+	linkParams := database.CreateMagicLinkParams{
+		ID:        link.ID,
+		UserID:    link.UserID,
+		Email:     link.Email,
+		ExpiresAt: time.Now().Add(magicLinkLifetime),
+	}
+	if err := cfg.dbQueries.CreateMagicLink(ctx, linkParams); err != nil {
+		return fmt.Errorf("creating magic link: %w", err)
+	}
+
+	token, err := cfg.keyring.MakeMagicLinkJWT(link, magicLinkLifetime)
+	if err != nil {
+		return err
+	}
+
+	// without a client page to open, the best we can do is the token itself
+	var howTo string
+	if cfg.magicLinkURL != "" {
+		howTo = fmt.Sprintf("Log in with this link: %s?token=%s", cfg.magicLinkURL, url.QueryEscape(token))
+	} else {
+		howTo = fmt.Sprintf("Your login token: %s\n"+
+			"Send it to POST %s/api/login/magic/consume", token, cfg.publicURL)
+	}
+
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Your Chirpy login link",
+		Body: fmt.Sprintf("Someone asked to log in to your Chirpy account without a password.\n\n"+
+			"%s\n\n"+
+			"It works once and expires in %d minutes. If it wasn't you, just ignore this email.",
+			howTo, int(magicLinkLifetime.Minutes())),
+	}
+	return cfg.mailer.Send(ctx, msg)
+}
+
+// body has "token" from the magic link mail
+// 1. check the signature and expiry
+// 2. use up the link (single-use)
+// 3. the account must still have the email the link was sent to
+// 4. same response as POST /api/login (2FA challenge if it's on)
+func (cfg *apiConfig) consumeMagicLink(w http.ResponseWriter, r *http.Request) {
+	type reqBodyStruct struct {
+		Token string `json:"token"`
+	}
+
+	var req reqBodyStruct
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	// 1.
+	claims, err := cfg.keyring.ValidateMagicLinkJWT(req.Token)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+
+	// 2.
+	link, err := cfg.dbQueries.ConsumeMagicLink(r.Context(), claims.ID)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+
+	// 3.
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), link.UserID)
+	if err != nil || link.UserID != claims.UserID || link.Email != claims.Email || user.Email != link.Email {
+		w.WriteHeader(401)
+		return
+	}
+
+	// 4.
+	if user.TotpEnabledAt.Valid {
+		cfg.respondWithTwoFactorChallenge(w, user)
+		return
+	}
+
+	cfg.respondWithLogin(w, r, user)
+}
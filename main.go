@@ -1,14 +1,26 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 
+	"github.com/WaronLimsakul/Chirpy/internal/audit"
+	"github.com/WaronLimsakul/Chirpy/internal/auth"
 	"github.com/WaronLimsakul/Chirpy/internal/database"
+	"github.com/WaronLimsakul/Chirpy/internal/entitlements"
+	"github.com/WaronLimsakul/Chirpy/internal/mailer"
+	"github.com/WaronLimsakul/Chirpy/internal/oidc"
+	"github.com/WaronLimsakul/Chirpy/internal/ratelimit"
+	"github.com/WaronLimsakul/Chirpy/internal/timeline"
+	"github.com/WaronLimsakul/Chirpy/internal/webhook"
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
@@ -17,36 +29,173 @@ import (
 type apiConfig struct {
 	// atomic type used when keeping track something across go routine
 	fileServerHits atomic.Int32
+	db             *sql.DB // for transactions, use dbQueries for everything else
 	dbQueries      *database.Queries
 	platform       string
-	tokenSecret    string
-	polkaKey       string
+	allowOpenReset bool          // ALLOW_UNAUTHENTICATED_RESET, see resetHandler
+	keyring        *auth.Keyring // access token signing keys
+	passwordHasher *auth.PasswordHasher
+	passwordPolicy *auth.PasswordPolicy // for new passwords
+	polkaWebhooks  webhook.Verifier
+	mailer         mailer.Mailer
+	publicURL      string // where users reach us, for links in emails
+	magicLinkURL   string // client page that takes ?token= from a magic link mail
+	oidcProviders  map[string]*oidc.Provider
+	// block unverified accounts from posting chirps
+	requireVerifiedEmail bool
+	// limits per plan (free, Chirpy Red), see entitlements.FromEnv
+	entitlements entitlements.Entitlements
+	auditLog     *audit.Logger
+	// fan-out-on-read or -write for home timelines, see cfg.timeline
+	timelineStrategy timeline.Strategy
+	// failed logins per email and per client IP
+	loginAccountLimiter *ratelimit.Limiter
+	loginIPLimiter      *ratelimit.Limiter
+	// mails anyone can trigger (password reset, magic link) per email, see mailThrottled
+	mailLimiter *ratelimit.Limiter
 }
 
 type User struct {
+	ID            uuid.UUID `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Email         string    `json:"email"`
+	IsChirpyRed   bool      `json:"is_chirpy_red"`
+	EmailVerified bool      `json:"email_verified"`
+	PendingEmail  string    `json:"pending_email,omitempty"` // waiting for verification
+	Role          string    `json:"role"`
+	Handle        string    `json:"handle,omitempty"`
+	DisplayName   string    `json:"display_name"`
+	Bio           string    `json:"bio"`
+	Location      string    `json:"location"`
+	Website       string    `json:"website"`
+}
+
+// what anyone may see about a user
+type PublicUser struct {
 	ID          uuid.UUID `json:"id"`
 	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
-	Email       string    `json:"email"`
+	IsChirpyRed bool      `json:"is_chirpy_red"`
+	Handle      string    `json:"handle,omitempty"`
+	DisplayName string    `json:"display_name"`
+	Bio         string    `json:"bio"`
+	Location    string    `json:"location"`
+	Website     string    `json:"website"`
+	Followers   int64     `json:"followers_count"`
+	Following   int64     `json:"following_count"`
+}
+
+// one user in a followers / following list
+type Follow struct {
+	UserID      uuid.UUID `json:"user_id"`
+	Handle      string    `json:"handle,omitempty"`
+	DisplayName string    `json:"display_name"`
+	IsChirpyRed bool      `json:"is_chirpy_red"`
+	FollowedAt  time.Time `json:"followed_at"`
+}
+
+// the author of a chirp, just enough to show it
+type ChirpAuthor struct {
+	ID          uuid.UUID `json:"id"`
+	Handle      string    `json:"handle,omitempty"`
+	DisplayName string    `json:"display_name"`
 	IsChirpyRed bool      `json:"is_chirpy_red"`
 }
 
 type LoggedInUser struct {
-	ID           uuid.UUID `json:"id"`
+	ID            uuid.UUID `json:"id"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Email         string    `json:"email"`
+	Token         string    `json:"token"`
+	RefreshToken  string    `json:"refresh_token"`
+	IsChirpyRed   bool      `json:"is_chirpy_red"`
+	EmailVerified bool      `json:"email_verified"`
+	Role          string    `json:"role"`
+}
+
+// a session is a refresh token family (one login on one device)
+type Session struct {
+	ID         uuid.UUID `json:"id"`
+	StartedAt  time.Time `json:"started_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+}
+
+// account at an external OIDC provider, linked to a user
+type Identity struct {
+	ID        uuid.UUID `json:"id"`
+	Provider  string    `json:"provider"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// third-party app registered by a user, the secret is only in the create response
+type OAuthClient struct {
+	ID           uuid.UUID `json:"client_id"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Confidential bool      `json:"confidential"`
 	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
-	Email        string    `json:"email"`
-	Token        string    `json:"token"`
-	RefreshToken string    `json:"refresh_token"`
-	IsChirpyRed  bool      `json:"is_chirpy_red"`
+	Secret       string    `json:"client_secret,omitempty"`
+}
+
+// a user's permission for an app to act for them
+type OAuthGrant struct {
+	ID         uuid.UUID `json:"id"`
+	ClientID   uuid.UUID `json:"client_id"`
+	ClientName string    `json:"client_name"`
+	Scopes     []string  `json:"scopes"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+// one line of the audit log, actor/target are null when unknown/not another user
+type AuditEvent struct {
+	ID        int64      `json:"id"`
+	CreatedAt time.Time  `json:"created_at"`
+	Action    string     `json:"action"`
+	Outcome   string     `json:"outcome"`
+	ActorID   *uuid.UUID `json:"actor_id"`
+	TargetID  *uuid.UUID `json:"target_id"`
+	IPAddress string     `json:"ip_address"`
+	UserAgent string     `json:"user_agent"`
+	Detail    string     `json:"detail"`
+}
+
+// personal access token, the token itself is only in the create response
+type PersonalAccessToken struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`   // null = never
+	LastUsedAt *time.Time `json:"last_used_at"` // null = never used
+	Token      string     `json:"token,omitempty"`
 }
 
 type Chirp struct {
 	ID        uuid.UUID `json:"id"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	PublishAt time.Time `json:"publish_at"`
 	Body      string    `json:"body"`
-	UserID    uuid.UUID `json:"user_id"`
+	// nil on a tombstone
+	UserID *uuid.UUID `json:"user_id"`
+	// nil if the author is gone between the two queries
+	Author     *ChirpAuthor `json:"author"`
+	InReplyTo  *uuid.UUID   `json:"in_reply_to"`
+	ReplyCount int64        `json:"reply_count"`
+	// a tombstone: deleted, but it has replies. body, author and user_id are gone
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// a chirp in a thread, with its replies under it
+type ThreadChirp struct {
+	Chirp
+	Replies []ThreadChirp `json:"replies"`
 }
 
 func main() {
@@ -55,12 +204,76 @@ func main() {
 
 	envPlatform := os.Getenv("PLATFORM")
 	state.platform = envPlatform
+	state.allowOpenReset = os.Getenv("ALLOW_UNAUTHENTICATED_RESET") == "true"
+
+	keyring, err := loadKeyring()
+	if err != nil {
+		log.Fatal(err)
+	}
+	state.keyring = keyring
 
-	envTokenSecret := os.Getenv("TOKEN_SECRET")
-	state.tokenSecret = envTokenSecret
+	polkaWebhooks, err := loadPolkaWebhooks()
+	if err != nil {
+		log.Fatal(err)
+	}
+	state.polkaWebhooks = polkaWebhooks
 
-	envPolkaKey := os.Getenv("POLKA_KEY")
-	state.polkaKey = envPolkaKey
+	mailer, err := loadMailer()
+	if err != nil {
+		log.Fatal(err)
+	}
+	state.mailer = mailer
+
+	passwordHasher, err := loadPasswordHasher()
+	if err != nil {
+		log.Fatal(err)
+	}
+	state.passwordHasher = passwordHasher
+
+	passwordPolicy, err := loadPasswordPolicy()
+	if err != nil {
+		log.Fatal(err)
+	}
+	state.passwordPolicy = passwordPolicy
+
+	accountLimiter, ipLimiter, err := loadLoginLimiters()
+	if err != nil {
+		log.Fatal(err)
+	}
+	state.loginAccountLimiter = accountLimiter
+	state.loginIPLimiter = ipLimiter
+	// a few mails per email for free, then one per minute, doubling up to one an hour
+	state.mailLimiter = ratelimit.New(ratelimit.Policy{
+		FreeAttempts: 3,
+		BaseDelay:    time.Minute,
+		MaxDelay:     time.Hour,
+		ForgetAfter:  time.Hour * 24,
+	})
+
+	state.requireVerifiedEmail = os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true"
+
+	state.entitlements, err = entitlements.FromEnv(os.Getenv)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	state.timelineStrategy, err = timeline.ParseStrategy(os.Getenv("TIMELINE_STRATEGY"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	state.publicURL = strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/")
+	if state.publicURL == "" {
+		state.publicURL = "http://localhost:8080"
+	}
+
+	state.magicLinkURL = os.Getenv("MAGIC_LINK_URL")
+
+	oidcProviders, err := loadOIDCProviders(state.publicURL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	state.oidcProviders = oidcProviders
 
 	dbURL := os.Getenv("DB_URL")
 	db, err := sql.Open("postgres", dbURL)
@@ -71,7 +284,43 @@ func main() {
 	// sqlc create this database package
 	// this function just connect the db to the queries
 	dbQueries := database.New(db)
+	state.db = db
 	state.dbQueries = dbQueries
+	state.auditLog = audit.New(dbQueries)
+
+	// first admin can't be made through the api, give it by email
+	if adminEmail := os.Getenv("ADMIN_EMAIL"); adminEmail != "" {
+		promoted, err := dbQueries.SetUserRoleByEmail(context.Background(), database.SetUserRoleByEmailParams{
+			Role:  string(auth.RoleAdmin),
+			Email: adminEmail,
+		})
+		if err != nil {
+			log.Fatal(err)
+		} else if promoted == 0 {
+			// someone else may have signed up with it, they don't get admin
+			if _, err := dbQueries.GetUserByEmail(context.Background(), adminEmail); err == nil {
+				log.Printf("WARNING: ADMIN_EMAIL %s has an account with an unverified email, NOT promoting it. verify the email and restart", adminEmail)
+			} else {
+				log.Printf("ADMIN_EMAIL %s has no account yet, sign up, verify the email and restart", adminEmail)
+			}
+		}
+	}
+
+	// mark Chirpy Red memberships that ran out without a renewal as expired
+	expiryInterval := time.Minute * 10
+	if envInterval := os.Getenv("SUBSCRIPTION_EXPIRY_INTERVAL"); envInterval != "" {
+		expiryInterval, err = time.ParseDuration(envInterval)
+		if err != nil || expiryInterval <= 0 {
+			log.Fatalf("invalid SUBSCRIPTION_EXPIRY_INTERVAL: %q", envInterval)
+		}
+	}
+	go state.expireSubscriptions(context.Background(), expiryInterval)
+
+	// timeline_entries isn't kept up to date on fan-out-on-read,
+	// catch up with what changed since the last time we ran on write
+	if state.timelineStrategy == timeline.FanOutOnWrite {
+		go state.rebuildTimelines(context.Background())
+	}
 
 	// servemux is like a server assistant
 	// - remember which request should go where
@@ -94,25 +343,392 @@ func main() {
 		w.Write([]byte("OK"))
 	})
 
-	serveMux.HandleFunc("GET /admin/metrics", state.reportFileServerHits)
-	serveMux.HandleFunc("POST /admin/reset", state.resetServer)
+	// public keys so other services can verify our access tokens
+	serveMux.HandleFunc("GET /.well-known/jwks.json", state.getJWKS)
+
+	serveMux.HandleFunc("GET /admin/metrics", state.requireRole(auth.RoleAdmin, state.reportFileServerHits))
+	serveMux.HandleFunc("POST /admin/reset", state.resetHandler()) // dev only and admin only, see resetHandler
+	serveMux.HandleFunc("POST /admin/keys/rotate", state.requireRole(auth.RoleAdmin, state.rotateSigningKey))
+	serveMux.HandleFunc("GET /admin/users", state.requireRole(auth.RoleAdmin, state.listUsers))
+	serveMux.HandleFunc("PUT /admin/users/{user_id}/role", state.requireRole(auth.RoleAdmin, state.setUserRole))
+	serveMux.HandleFunc("DELETE /admin/users/{user_id}", state.requireRole(auth.RoleAdmin, state.deleteUser))
+	serveMux.HandleFunc("GET /admin/audit", state.requireRole(auth.RoleAdmin, state.listAuditEvents))
 
 	// serveMux.HandleFunc("POST /api/validate_chirp", validateChirp)
 	serveMux.HandleFunc("POST /api/chirps", state.createChirp)
 	serveMux.HandleFunc("GET /api/chirps", state.getAllChirps)
-	serveMux.HandleFunc("GET /api/chirps/{chirp_id}", state.getChirpByID)   // {?} is a wildcard
+	serveMux.HandleFunc("GET /api/chirps/scheduled", state.listScheduledChirps)
+	serveMux.HandleFunc("GET /api/chirps/{chirp_id}/thread", state.getChirpThread)
+	serveMux.HandleFunc("GET /api/timeline", state.getTimeline)
+	serveMux.HandleFunc("GET /api/chirps/{chirp_id}", state.getChirpByID) // {?} is a wildcard
+	serveMux.HandleFunc("PUT /api/chirps/{chirp_id}", state.editChirp)
 	serveMux.HandleFunc("DELETE /api/chirps/{chirp_id}", state.deleteChirp) // {?} is a wildcard
 
 	serveMux.HandleFunc("POST /api/users", state.createUser)
 	serveMux.HandleFunc("PUT /api/users", state.updateUser)
+	serveMux.HandleFunc("GET /api/users/me", state.getMe)
+	serveMux.HandleFunc("DELETE /api/users/me", state.deleteMe)
+	serveMux.HandleFunc("PUT /api/users/me/profile", state.updateProfile)
+	serveMux.HandleFunc("GET /api/users/{user_id}", state.getUserProfile)
+	serveMux.HandleFunc("GET /api/users/by-handle/{handle}", state.getUserByHandle)
+	serveMux.HandleFunc("POST /api/users/{user_id}/follow", state.followUser)
+	serveMux.HandleFunc("DELETE /api/users/{user_id}/follow", state.unfollowUser)
+	// not under /api/users/{user_id}/, that would clash with by-handle/{handle}
+	serveMux.HandleFunc("GET /api/followers/{user_id}", state.listFollowers)
+	serveMux.HandleFunc("GET /api/following/{user_id}", state.listFollowing)
 	serveMux.HandleFunc("POST /api/login", state.loginUser)
+	serveMux.HandleFunc("POST /api/login/2fa", state.loginTwoFactor)
+	serveMux.HandleFunc("POST /api/login/magic", state.sendMagicLink)
+	serveMux.HandleFunc("POST /api/login/magic/consume", state.consumeMagicLink)
 	serveMux.HandleFunc("POST /api/refresh", state.refreshUser)
 	serveMux.HandleFunc("POST /api/revoke", state.revokeToken)
 
-	serveMux.HandleFunc("POST /api/polka/webhooks", state.reddenUser)
+	serveMux.HandleFunc("GET /api/oauth/{provider}/start", state.startOAuth)
+	serveMux.HandleFunc("GET /api/oauth/{provider}/callback", state.oauthCallback)
+	serveMux.HandleFunc("POST /api/oauth/{provider}/link", state.linkOAuth)
+	serveMux.HandleFunc("GET /api/identities", state.listIdentities)
+	serveMux.HandleFunc("DELETE /api/identities/{identity_id}", state.unlinkIdentity)
+
+	serveMux.HandleFunc("POST /api/2fa/setup", state.setupTwoFactor)
+	serveMux.HandleFunc("POST /api/2fa/verify", state.verifyTwoFactor)
+
+	serveMux.HandleFunc("GET /api/verify", state.verifyEmail)
+	serveMux.HandleFunc("POST /api/verify/resend", state.resendEmailVerification)
+
+	serveMux.HandleFunc("POST /api/password/forgot", state.forgotPassword)
+	serveMux.HandleFunc("POST /api/password/reset", state.resetPassword)
+
+	serveMux.HandleFunc("GET /api/sessions", state.listSessions)
+	serveMux.HandleFunc("DELETE /api/sessions", state.revokeAllSessions) // log out everywhere
+	serveMux.HandleFunc("DELETE /api/sessions/{session_id}", state.revokeSession)
+
+	serveMux.HandleFunc("POST /api/tokens", state.createPersonalAccessToken)
+	serveMux.HandleFunc("GET /api/tokens", state.listPersonalAccessTokens)
+	serveMux.HandleFunc("DELETE /api/tokens/{token_id}", state.revokePersonalAccessToken)
+
+	serveMux.HandleFunc("POST /api/oauth/clients", state.createOAuthClient)
+	serveMux.HandleFunc("GET /api/oauth/clients", state.listOAuthClients)
+	serveMux.HandleFunc("DELETE /api/oauth/clients/{client_id}", state.deleteOAuthClient)
+	serveMux.HandleFunc("GET /api/oauth/grants", state.listOAuthGrants)
+	serveMux.HandleFunc("DELETE /api/oauth/grants/{grant_id}", state.revokeOAuthGrant)
+
+	// we as the OAuth2 authorization server, for third-party apps
+	serveMux.HandleFunc("GET /oauth/authorize", state.oauthAuthorize)
+	serveMux.HandleFunc("POST /oauth/authorize", state.oauthConsent)
+	serveMux.HandleFunc("POST /oauth/token", state.oauthToken)
+	serveMux.HandleFunc("POST /oauth/introspect", state.oauthIntrospect)
+	serveMux.HandleFunc("POST /oauth/revoke", state.oauthRevoke)
+
+	serveMux.HandleFunc("POST /api/polka/webhooks", state.polkaWebhook)
 
 	server := &http.Server{Handler: serveMux, Addr: ":8080"}
 
 	log.Printf("Listen to port 8080\n")
 	log.Fatal(server.ListenAndServe())
 }
+
+// keyring env:
+// - TOKEN_SIGNING_KEY_FILE: PEM private key (RSA -> RS256, Ed25519 -> EdDSA)
+// - TOKEN_SECRET: HS256 secret, used when there is no key file
+// - TOKEN_KEY_ID: "kid" of the active key (default "default")
+// - TOKEN_RETIRED_KEYS: "kid:secret,kid:secret" HS256 keys that should still validate
+// - TOKEN_RETIRED_KEY_FILES: "kid:path,kid:path" PEM keys that should still validate
+// - TOKEN_KEY_GRACE: how long retired keys keep validating (default 1h)
+func loadKeyring() (*auth.Keyring, error) {
+	grace := time.Hour
+	if envGrace := os.Getenv("TOKEN_KEY_GRACE"); envGrace != "" {
+		parsedGrace, err := time.ParseDuration(envGrace)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TOKEN_KEY_GRACE: %w", err)
+		}
+		grace = parsedGrace
+	}
+
+	keyring := auth.NewKeyring(grace)
+
+	for _, pair := range strings.Split(os.Getenv("TOKEN_RETIRED_KEYS"), ",") {
+		if pair == "" {
+			continue
+		}
+		kid, secret, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid TOKEN_RETIRED_KEYS entry, want kid:secret")
+		}
+		key, err := auth.NewHMACKey(kid, []byte(secret))
+		if err != nil {
+			return nil, err
+		}
+		if err := keyring.AddRetiredKey(key); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, pair := range strings.Split(os.Getenv("TOKEN_RETIRED_KEY_FILES"), ",") {
+		if pair == "" {
+			continue
+		}
+		kid, path, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid TOKEN_RETIRED_KEY_FILES entry, want kid:path")
+		}
+		key, err := auth.LoadPEMKey(kid, path)
+		if err != nil {
+			return nil, err
+		}
+		if err := keyring.AddRetiredKey(key); err != nil {
+			return nil, err
+		}
+	}
+
+	kid := os.Getenv("TOKEN_KEY_ID")
+	if kid == "" {
+		kid = "default"
+	}
+
+	var activeKey *auth.SigningKey
+	var err error
+	if keyFile := os.Getenv("TOKEN_SIGNING_KEY_FILE"); keyFile != "" {
+		activeKey, err = auth.LoadPEMKey(kid, keyFile)
+	} else {
+		activeKey, err = auth.NewHMACKey(kid, []byte(os.Getenv("TOKEN_SECRET")))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := keyring.Rotate(activeKey); err != nil {
+		return nil, err
+	}
+
+	return keyring, nil
+}
+
+// mailer env:
+// - MAILER: "smtp" or "log" (default "log", prints mails instead of sending)
+// - MAIL_LOG_FILE: where "log" writes, stdout if empty
+// - SMTP_HOST, SMTP_PORT (default 587), SMTP_USERNAME, SMTP_PASSWORD, MAIL_FROM
+func loadMailer() (mailer.Mailer, error) {
+	switch os.Getenv("MAILER") {
+	case "smtp":
+		host := os.Getenv("SMTP_HOST")
+		from := os.Getenv("MAIL_FROM")
+		if host == "" || from == "" {
+			return nil, fmt.Errorf("MAILER=smtp needs SMTP_HOST and MAIL_FROM")
+		}
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		return mailer.NewSMTPMailer(host, port, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), from)
+	case "log", "":
+		logFile := os.Getenv("MAIL_LOG_FILE")
+		if logFile == "" {
+			return mailer.NewLogMailer(os.Stdout), nil
+		}
+		file, err := os.OpenFile(logFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+		return mailer.NewLogMailer(file), nil
+	default:
+		return nil, fmt.Errorf("unknown MAILER %q", os.Getenv("MAILER"))
+	}
+}
+
+// password hashing env, only for new hashes, old ones keep working
+// and get upgraded at login:
+// - PASSWORD_HASH: "argon2id" (default) or "bcrypt"
+// - BCRYPT_COST: default 10
+// - ARGON2_MEMORY (KiB), ARGON2_ITERATIONS, ARGON2_PARALLELISM: default 19456, 2, 1
+func loadPasswordHasher() (*auth.PasswordHasher, error) {
+	algorithm := os.Getenv("PASSWORD_HASH")
+	if algorithm == "" {
+		algorithm = auth.AlgorithmArgon2id
+	}
+
+	bcryptCost := 10
+	argon2Params := auth.DefaultArgon2Params
+
+	for _, setting := range []struct {
+		name  string
+		value *uint32
+	}{
+		{"ARGON2_MEMORY", &argon2Params.Memory},
+		{"ARGON2_ITERATIONS", &argon2Params.Iterations},
+	} {
+		if env := os.Getenv(setting.name); env != "" {
+			parsed, err := strconv.ParseUint(env, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", setting.name, err)
+			}
+			*setting.value = uint32(parsed)
+		}
+	}
+
+	if env := os.Getenv("ARGON2_PARALLELISM"); env != "" {
+		parsed, err := strconv.ParseUint(env, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ARGON2_PARALLELISM: %w", err)
+		}
+		argon2Params.Parallelism = uint8(parsed)
+	}
+
+	if env := os.Getenv("BCRYPT_COST"); env != "" {
+		parsed, err := strconv.Atoi(env)
+		if err != nil {
+			return nil, fmt.Errorf("invalid BCRYPT_COST: %w", err)
+		}
+		bcryptCost = parsed
+	}
+
+	return auth.NewPasswordHasher(algorithm, bcryptCost, argon2Params)
+}
+
+// password policy env:
+// - PASSWORD_MIN_LENGTH: default 8
+// - PASSWORD_MAX_LENGTH: default 128
+// - BREACHED_PASSWORDS_FILE: SHA-1 hashes of breached passwords, one per line
+func loadPasswordPolicy() (*auth.PasswordPolicy, error) {
+	policy := &auth.PasswordPolicy{MinLength: 8, MaxLength: 128}
+
+	if env := os.Getenv("PASSWORD_MIN_LENGTH"); env != "" {
+		parsed, err := strconv.Atoi(env)
+		if err != nil || parsed < 1 {
+			return nil, fmt.Errorf("invalid PASSWORD_MIN_LENGTH %q", env)
+		}
+		policy.MinLength = parsed
+	}
+
+	if env := os.Getenv("PASSWORD_MAX_LENGTH"); env != "" {
+		parsed, err := strconv.Atoi(env)
+		if err != nil || parsed < policy.MinLength {
+			return nil, fmt.Errorf("invalid PASSWORD_MAX_LENGTH %q", env)
+		}
+		policy.MaxLength = parsed
+	}
+
+	if path := os.Getenv("BREACHED_PASSWORDS_FILE"); path != "" {
+		breached, err := auth.LoadBreachedPasswords(path)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("loaded %d breached password hashes", breached.Len())
+		policy.Breached = breached
+	}
+
+	return policy, nil
+}
+
+// OIDC env:
+// - OIDC_PROVIDERS: names, e.g. "company,google"
+// - OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID, OIDC_<NAME>_CLIENT_SECRET (optional)
+// - OIDC_<NAME>_SCOPES: default "openid email profile"
+// the redirect url to register at the provider is PUBLIC_URL/api/oauth/<name>/callback
+func loadOIDCProviders(publicURL string) (map[string]*oidc.Provider, error) {
+	providers := map[string]*oidc.Provider{}
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		scopes := strings.Fields(os.Getenv(prefix + "SCOPES"))
+		if len(scopes) == 0 {
+			scopes = []string{"openid", "email", "profile"}
+		}
+
+		provider, err := oidc.NewProvider(oidc.Config{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  publicURL + "/api/oauth/" + name + "/callback",
+			Scopes:       scopes,
+		}, nil)
+		if err != nil {
+			return nil, err
+		}
+		providers[name] = provider
+	}
+	return providers, nil
+}
+
+// polka webhook env:
+// - POLKA_WEBHOOK_SECRETS: "secret,secret" every secret Polka may sign with,
+// more than one only while rotating
+// - POLKA_WEBHOOK_TOLERANCE: how old (or early) a delivery may be (default 5m)
+func loadPolkaWebhooks() (webhook.Verifier, error) {
+	verifier := webhook.Verifier{Tolerance: time.Minute * 5}
+
+	if envTolerance := os.Getenv("POLKA_WEBHOOK_TOLERANCE"); envTolerance != "" {
+		tolerance, err := time.ParseDuration(envTolerance)
+		if err != nil || tolerance <= 0 {
+			return webhook.Verifier{}, fmt.Errorf("invalid POLKA_WEBHOOK_TOLERANCE: %q", envTolerance)
+		}
+		verifier.Tolerance = tolerance
+	}
+
+	for _, secret := range strings.Split(os.Getenv("POLKA_WEBHOOK_SECRETS"), ",") {
+		if secret = strings.TrimSpace(secret); secret != "" {
+			verifier.Secrets = append(verifier.Secrets, []byte(secret))
+		}
+	}
+	if len(verifier.Secrets) == 0 {
+		log.Printf("POLKA_WEBHOOK_SECRETS is not set, polka webhooks will be refused")
+	}
+
+	return verifier, nil
+}
+
+// login throttling env (failures in a row, see ratelimit.Policy):
+// - LOGIN_FREE_ATTEMPTS: failures per email before backoff starts (default 5)
+// - LOGIN_LOCKOUT_AFTER: failures per email before lockout (default 10, 0 = never)
+// - LOGIN_IP_FREE_ATTEMPTS, LOGIN_IP_LOCKOUT_AFTER: same per client IP (default 20, 100)
+// - LOGIN_BACKOFF_BASE, LOGIN_BACKOFF_MAX: first and longest backoff (default 1s, 5m)
+// - LOGIN_LOCKOUT_DURATION: default 15m
+// - LOGIN_FORGET_AFTER: failures are forgotten after this long without one (default 1h)
+func loadLoginLimiters() (*ratelimit.Limiter, *ratelimit.Limiter, error) {
+	var err error
+	envInt := func(name string, fallback int) int {
+		value := os.Getenv(name)
+		if value == "" || err != nil {
+			return fallback
+		}
+		parsed, parseErr := strconv.Atoi(value)
+		if parseErr != nil || parsed < 0 {
+			err = fmt.Errorf("invalid %s: %q", name, value)
+		}
+		return parsed
+	}
+	envDuration := func(name string, fallback time.Duration) time.Duration {
+		value := os.Getenv(name)
+		if value == "" || err != nil {
+			return fallback
+		}
+		parsed, parseErr := time.ParseDuration(value)
+		if parseErr != nil || parsed < 0 {
+			err = fmt.Errorf("invalid %s: %q", name, value)
+		}
+		return parsed
+	}
+
+	accountPolicy := ratelimit.Policy{
+		FreeAttempts:    envInt("LOGIN_FREE_ATTEMPTS", 5),
+		BaseDelay:       envDuration("LOGIN_BACKOFF_BASE", time.Second),
+		MaxDelay:        envDuration("LOGIN_BACKOFF_MAX", time.Minute*5),
+		LockoutAfter:    envInt("LOGIN_LOCKOUT_AFTER", 10),
+		LockoutDuration: envDuration("LOGIN_LOCKOUT_DURATION", time.Minute*15),
+		ForgetAfter:     envDuration("LOGIN_FORGET_AFTER", time.Hour),
+	}
+
+	// many users can share an IP (office, NAT), so it gets more room
+	ipPolicy := accountPolicy
+	ipPolicy.FreeAttempts = envInt("LOGIN_IP_FREE_ATTEMPTS", 20)
+	ipPolicy.LockoutAfter = envInt("LOGIN_IP_LOCKOUT_AFTER", 100)
+
+	if err != nil {
+		return nil, nil, err
+	}
+	return ratelimit.New(accountPolicy), ratelimit.New(ipPolicy), nil
+}
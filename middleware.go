@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/WaronLimsakul/Chirpy/internal/auth"
+	"github.com/google/uuid"
+)
+
+// key for the authenticated claims in a request context
+type claimsContextKey struct{}
+
+// authenticate with accountOnly: personal access tokens and third-party app
+// tokens are refused, for things like sessions, 2FA, tokens... that only a login should touch
+const accountOnly auth.Scope = ""
+
+// the token is fine but not allowed here -> 403 instead of 401
+var errMissingScope = errors.New("token doesn't have the required scope")
+
+// check "Authorization: Bearer <token>", return who it belongs to.
+// the token is an access token (JWT) from login or from a third-party app (OAuth),
+// or a personal access token. The last two must have the scope.
+func (cfg *apiConfig) authenticate(r *http.Request, scope auth.Scope) (auth.AccessClaims, error) {
+	reqToken, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return auth.AccessClaims{}, err
+	}
+
+	var claims auth.AccessClaims
+	if auth.IsPersonalAccessToken(reqToken) {
+		claims, err = cfg.personalAccessTokenClaims(r.Context(), reqToken)
+	} else {
+		claims, err = cfg.keyring.ValidateJWT(reqToken)
+		if err == nil && claims.GrantID != uuid.Nil {
+			err = cfg.checkOAuthGrant(r.Context(), claims)
+		}
+	}
+	if err != nil {
+		return auth.AccessClaims{}, err
+	}
+
+	// scopes == nil is a login token, it can do everything
+	if claims.Scopes != nil && (scope == accountOnly || !claims.HasScope(scope)) {
+		return auth.AccessClaims{}, errMissingScope
+	}
+
+	return claims, nil
+}
+
+func (cfg *apiConfig) personalAccessTokenClaims(ctx context.Context, token string) (auth.AccessClaims, error) {
+	pat, err := cfg.dbQueries.GetPersonalAccessToken(ctx, auth.HashToken(token))
+	if err != nil {
+		return auth.AccessClaims{}, fmt.Errorf("unknown personal access token: %w", err)
+	}
+
+	claims := auth.AccessClaims{
+		UserID: pat.UserID,
+		Role:   auth.Role(pat.Role),
+		Scopes: []auth.Scope{},
+	}
+	for _, s := range pat.Scopes {
+		claims.Scopes = append(claims.Scopes, auth.Scope(s))
+	}
+
+	if err := cfg.dbQueries.TouchPersonalAccessToken(ctx, pat.ID); err != nil {
+		log.Printf("error updating last_used_at of token %s: %s", pat.ID, err)
+	}
+
+	return claims, nil
+}
+
+// an app's access token dies with its grant, even before it expires
+func (cfg *apiConfig) checkOAuthGrant(ctx context.Context, claims auth.AccessClaims) error {
+	grant, err := cfg.dbQueries.GetOAuthGrant(ctx, claims.GrantID)
+	if err != nil {
+		return fmt.Errorf("unknown grant: %w", err)
+	}
+	if grant.RevokedAt.Valid || grant.UserID != claims.UserID || grant.ClientID != claims.ClientID {
+		return fmt.Errorf("grant %s is revoked", grant.ID)
+	}
+	return nil
+}
+
+// status code for an authenticate error
+func authErrorStatus(err error) int {
+	if errors.Is(err, errMissingScope) {
+		return 403
+	}
+	return 401
+}
+
+// wrap a handler so only users with at least this role get through.
+// no/invalid token -> 401, role too low -> 403.
+// the role in a token can be an hour old, so we check db too,
+// a demoted admin shouldn't keep admin powers until the token expires.
+// the handler can get the claims with claimsFromContext
+func (cfg *apiConfig) requireRole(role auth.Role, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := cfg.authenticate(r, accountOnly)
+		if err != nil {
+			w.WriteHeader(authErrorStatus(err))
+			return
+		}
+
+		if !claims.Role.Allows(role) {
+			w.WriteHeader(403)
+			return
+		}
+
+		user, err := cfg.dbQueries.GetUserByID(r.Context(), claims.UserID)
+		if err != nil {
+			w.WriteHeader(401)
+			return
+		}
+
+		if !auth.Role(user.Role).Allows(role) {
+			log.Printf("user %s has a stale %s token", user.ID, claims.Role)
+			w.WriteHeader(403)
+			return
+		}
+		claims.Role = auth.Role(user.Role)
+
+		ctx := context.WithValue(r.Context(), claimsContextKey{}, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// claims put in the context by requireRole
+func claimsFromContext(ctx context.Context) (auth.AccessClaims, bool) {
+	claims, ok := ctx.Value(claimsContextKey{}).(auth.AccessClaims)
+	return claims, ok
+}
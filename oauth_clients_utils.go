@@ -0,0 +1,245 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/WaronLimsakul/Chirpy/internal/auth"
+	"github.com/WaronLimsakul/Chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// database.OauthClient -> OAuthClient, without the secret
+func toOAuthClient(client database.OauthClient) OAuthClient {
+	return OAuthClient{
+		ID:           client.ID,
+		Name:         client.Name,
+		RedirectURIs: client.RedirectUris,
+		Confidential: client.SecretHash.Valid,
+		CreatedAt:    client.CreatedAt,
+	}
+}
+
+// https only, except http on localhost for development.
+// no fragment (RFC 6749 3.1.2), we append the code as a query param
+func validRedirectURI(rawURI string) bool {
+	parsed, err := url.Parse(rawURI)
+	if err != nil || !parsed.IsAbs() || parsed.Host == "" || parsed.Fragment != "" || parsed.User != nil {
+		return false
+	}
+	switch parsed.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := parsed.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	default:
+		return false
+	}
+}
+
+// register a third-party app
+// Request body has => "name", "redirect_uris" and "confidential"
+// - confidential apps (with a server) get a client_secret, shown only this once
+// - public apps (mobile, single page) have no secret and rely on PKCE alone
+func (cfg *apiConfig) createOAuthClient(w http.ResponseWriter, r *http.Request) {
+	claims, err := cfg.authenticate(r, accountOnly)
+	if err != nil {
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+
+	type reqBodyStruct struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Confidential bool     `json:"confidential"`
+	}
+	reqBody := reqBodyStruct{}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	reqBody.Name = strings.TrimSpace(reqBody.Name)
+	if reqBody.Name == "" || len(reqBody.Name) > 100 || len(reqBody.RedirectURIs) == 0 || len(reqBody.RedirectURIs) > 10 {
+		w.WriteHeader(400)
+		return
+	}
+	for _, redirectURI := range reqBody.RedirectURIs {
+		if !validRedirectURI(redirectURI) {
+			w.WriteHeader(400)
+			return
+		}
+	}
+
+	var secret string
+	secretHash := sql.NullString{}
+	if reqBody.Confidential {
+		secret, err = auth.MakeRefreshToken() // same thing, 256-bit random
+		if err != nil {
+			log.Printf("%s", err)
+			w.WriteHeader(500)
+			return
+		}
+		secretHash = sql.NullString{String: auth.HashToken(secret), Valid: true}
+	}
+
+	client, err := cfg.dbQueries.CreateOAuthClient(r.Context(), database.CreateOAuthClientParams{
+		OwnerID:      claims.UserID,
+		Name:         reqBody.Name,
+		RedirectUris: reqBody.RedirectURIs,
+		SecretHash:   secretHash,
+	})
+	if err != nil {
+		log.Printf("error creating oauth client: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	res := toOAuthClient(client)
+	res.Secret = secret
+
+	resData, err := json.Marshal(res)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(201)
+	w.Write(resData)
+}
+
+// apps the user registered
+func (cfg *apiConfig) listOAuthClients(w http.ResponseWriter, r *http.Request) {
+	claims, err := cfg.authenticate(r, accountOnly)
+	if err != nil {
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+
+	clients, err := cfg.dbQueries.ListUserOAuthClients(r.Context(), claims.UserID)
+	if err != nil {
+		log.Printf("error listing oauth clients: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	resClients := []OAuthClient{}
+	for _, client := range clients {
+		resClients = append(resClients, toOAuthClient(client))
+	}
+
+	resData, err := json.Marshal(resClients)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(resData)
+}
+
+// delete an app the user registered, with every grant and code of it (db cascades)
+func (cfg *apiConfig) deleteOAuthClient(w http.ResponseWriter, r *http.Request) {
+	claims, err := cfg.authenticate(r, accountOnly)
+	if err != nil {
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+
+	clientID, err := uuid.Parse(r.PathValue("client_id"))
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	deleted, err := cfg.dbQueries.DeleteOAuthClient(r.Context(), database.DeleteOAuthClientParams{
+		ID:      clientID,
+		OwnerID: claims.UserID,
+	})
+	if err != nil {
+		log.Printf("error deleting oauth client: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if deleted == 0 {
+		w.WriteHeader(404)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+// apps the user allowed to act for them
+func (cfg *apiConfig) listOAuthGrants(w http.ResponseWriter, r *http.Request) {
+	claims, err := cfg.authenticate(r, accountOnly)
+	if err != nil {
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+
+	grants, err := cfg.dbQueries.ListUserOAuthGrants(r.Context(), claims.UserID)
+	if err != nil {
+		log.Printf("error listing oauth grants: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	resGrants := []OAuthGrant{}
+	for _, grant := range grants {
+		resGrants = append(resGrants, OAuthGrant{
+			ID:         grant.ID,
+			ClientID:   grant.ClientID,
+			ClientName: grant.ClientName,
+			Scopes:     grant.Scopes,
+			CreatedAt:  grant.CreatedAt,
+			LastUsedAt: grant.LastUsedAt,
+		})
+	}
+
+	resData, err := json.Marshal(resGrants)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(resData)
+}
+
+// take back an app's access. its access and refresh tokens stop working right away
+func (cfg *apiConfig) revokeOAuthGrant(w http.ResponseWriter, r *http.Request) {
+	claims, err := cfg.authenticate(r, accountOnly)
+	if err != nil {
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+
+	grantID, err := uuid.Parse(r.PathValue("grant_id"))
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	revoked, err := cfg.dbQueries.RevokeUserOAuthGrant(r.Context(), database.RevokeUserOAuthGrantParams{
+		ID:     grantID,
+		UserID: claims.UserID,
+	})
+	if err != nil {
+		log.Printf("error revoking oauth grant: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if revoked == 0 {
+		w.WriteHeader(404)
+		return
+	}
+
+	w.WriteHeader(204)
+}
@@ -0,0 +1,642 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/WaronLimsakul/Chirpy/internal/audit"
+	"github.com/WaronLimsakul/Chirpy/internal/auth"
+	"github.com/WaronLimsakul/Chirpy/internal/database"
+	"github.com/WaronLimsakul/Chirpy/internal/oidc"
+	"github.com/google/uuid"
+)
+
+// here Chirpy is the OAuth2 authorization server (RFC 6749), third-party apps
+// registered at /api/oauth/clients get users' permission to act for them.
+// only the authorization code grant, and only with PKCE S256 (RFC 7636)
+
+const authorizationCodeLifetime = time.Minute * 5
+
+const oauthAccessTokenLifetime = time.Hour
+
+// what the user sees on the consent page for each scope
+var scopeDescriptions = map[auth.Scope]string{
+	auth.ScopeChirpsRead:  "Read chirps",
+	auth.ScopeChirpsWrite: "Post and delete chirps as you",
+}
+
+// the credentials are typed right into this page, there is no cookie session,
+// so a forged POST can't do anything without the password
+var consentTemplate = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Authorize {{.ClientName}} - Chirpy</title></head>
+<body>
+<h1>{{.ClientName}} wants to use your Chirpy account</h1>
+<p>It will be able to:</p>
+<ul>{{range .Scopes}}<li>{{.}}</li>{{end}}</ul>
+{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+<form method="POST" action="/oauth/authorize">
+<input type="hidden" name="response_type" value="code">
+<input type="hidden" name="client_id" value="{{.ClientID}}">
+<input type="hidden" name="redirect_uri" value="{{.RedirectURI}}">
+<input type="hidden" name="scope" value="{{.Scope}}">
+<input type="hidden" name="state" value="{{.State}}">
+<input type="hidden" name="code_challenge" value="{{.CodeChallenge}}">
+<input type="hidden" name="code_challenge_method" value="S256">
+<p><label>Email <input type="email" name="email" value="{{.Email}}"></label></p>
+<p><label>Password <input type="password" name="password"></label></p>
+<p><label>2FA code (if enabled) <input type="text" name="code" autocomplete="one-time-code"></label></p>
+<button type="submit" name="decision" value="allow">Allow</button>
+<button type="submit" name="decision" value="deny">Deny</button>
+</form>
+</body>
+</html>
+`))
+
+// a checked /oauth/authorize request
+type authorizeRequest struct {
+	Client        database.OauthClient
+	RedirectURI   string
+	Scopes        []auth.Scope
+	State         string
+	CodeChallenge string
+}
+
+// an /oauth/authorize error. before the client and redirect_uri are known good
+// we must not redirect anywhere (open redirector), so redirect = false
+type authorizeError struct {
+	Code        string
+	Description string
+	Redirect    bool
+}
+
+// check the query (GET) or form (POST) of /oauth/authorize
+// 1. client_id must exist
+// 2. redirect_uri must be one of the client's, exactly
+// 3. only response_type=code
+// 4. PKCE is required, S256 only ("plain" gives nothing over no PKCE)
+// 5. every scope must be known
+func (cfg *apiConfig) parseAuthorizeRequest(r *http.Request, values url.Values) (authorizeRequest, *authorizeError) {
+	// 1.
+	clientID, err := uuid.Parse(values.Get("client_id"))
+	if err != nil {
+		return authorizeRequest{}, &authorizeError{Code: "invalid_request", Description: "unknown client_id"}
+	}
+	client, err := cfg.dbQueries.GetOAuthClient(r.Context(), clientID)
+	if err != nil {
+		return authorizeRequest{}, &authorizeError{Code: "invalid_request", Description: "unknown client_id"}
+	}
+
+	// 2.
+	redirectURI := values.Get("redirect_uri")
+	if !slices.Contains(client.RedirectUris, redirectURI) {
+		return authorizeRequest{}, &authorizeError{Code: "invalid_request", Description: "redirect_uri is not registered for this client"}
+	}
+
+	req := authorizeRequest{
+		Client:        client,
+		RedirectURI:   redirectURI,
+		State:         values.Get("state"),
+		CodeChallenge: values.Get("code_challenge"),
+	}
+
+	// 3.
+	if values.Get("response_type") != "code" {
+		return req, &authorizeError{Code: "unsupported_response_type", Redirect: true}
+	}
+
+	// 4.
+	// a challenge is base64url(sha256), always 43 chars
+	if len(req.CodeChallenge) != 43 || values.Get("code_challenge_method") != "S256" {
+		return req, &authorizeError{Code: "invalid_request", Description: "code_challenge with code_challenge_method=S256 is required", Redirect: true}
+	}
+
+	// 5.
+	req.Scopes, err = auth.ParseScopes(strings.Fields(values.Get("scope")))
+	if err != nil {
+		return req, &authorizeError{Code: "invalid_scope", Description: err.Error(), Redirect: true}
+	}
+
+	return req, nil
+}
+
+// send the user back to the app with a code or an error
+func redirectToClient(w http.ResponseWriter, r *http.Request, req authorizeRequest, params url.Values) {
+	target, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	query := target.Query()
+	for key, value := range params {
+		query[key] = value
+	}
+	target.RawQuery = query.Encode()
+
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+func (cfg *apiConfig) respondWithAuthorizeError(w http.ResponseWriter, r *http.Request, req authorizeRequest, authErr *authorizeError) {
+	if !authErr.Redirect {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(400)
+		w.Write([]byte(authErr.Description + "\n"))
+		return
+	}
+
+	params := url.Values{"error": {authErr.Code}}
+	if authErr.Description != "" {
+		params.Set("error_description", authErr.Description)
+	}
+	redirectToClient(w, r, req, params)
+}
+
+// render the consent page, with an error message after a failed attempt
+func renderConsent(w http.ResponseWriter, req authorizeRequest, email, errMessage string, status int) {
+	type consentData struct {
+		ClientName    string
+		ClientID      uuid.UUID
+		RedirectURI   string
+		Scope         string
+		Scopes        []string
+		State         string
+		CodeChallenge string
+		Email         string
+		Error         string
+	}
+
+	data := consentData{
+		ClientName:    req.Client.Name,
+		ClientID:      req.Client.ID,
+		RedirectURI:   req.RedirectURI,
+		State:         req.State,
+		CodeChallenge: req.CodeChallenge,
+		Email:         email,
+		Error:         errMessage,
+	}
+	scopeStrings := []string{}
+	for _, scope := range req.Scopes {
+		scopeStrings = append(scopeStrings, string(scope))
+		data.Scopes = append(data.Scopes, scopeDescriptions[scope])
+	}
+	data.Scope = strings.Join(scopeStrings, " ")
+
+	// nobody should frame the page and trick the user into clicking "Allow"
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := consentTemplate.Execute(w, data); err != nil {
+		log.Printf("error rendering consent page: %s", err)
+	}
+}
+
+// GET /oauth/authorize: where an app sends the user, show the consent page
+func (cfg *apiConfig) oauthAuthorize(w http.ResponseWriter, r *http.Request) {
+	req, authErr := cfg.parseAuthorizeRequest(r, r.URL.Query())
+	if authErr != nil {
+		cfg.respondWithAuthorizeError(w, r, req, authErr)
+		return
+	}
+
+	renderConsent(w, req, "", "", 200)
+}
+
+// POST /oauth/authorize: the consent form
+// 1. check the request again, the hidden fields come from the browser
+// 2. "deny" -> back to the app with access_denied
+// 3. check email, password and 2FA code (throttled like /api/login)
+// 4. create a single-use code bound to the client, redirect_uri and PKCE challenge
+// 5. back to the app with the code
+func (cfg *apiConfig) oauthConsent(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	// 1.
+	req, authErr := cfg.parseAuthorizeRequest(r, r.PostForm)
+	if authErr != nil {
+		cfg.respondWithAuthorizeError(w, r, req, authErr)
+		return
+	}
+
+	// 2.
+	if r.PostForm.Get("decision") != "allow" {
+		redirectToClient(w, r, req, url.Values{"error": {"access_denied"}})
+		return
+	}
+
+	// 3.
+	email := r.PostForm.Get("email")
+	password := r.PostForm.Get("password")
+	if cfg.loginThrottled(w, r, email) {
+		return
+	}
+
+	user, err := cfg.dbQueries.GetUserByEmail(r.Context(), email)
+	if err != nil {
+		cfg.passwordHasher.CheckDummy(password)
+		cfg.loginFailed(r, email)
+		renderConsent(w, req, email, "Wrong email or password.", 401)
+		return
+	}
+
+	if err := cfg.passwordHasher.Check(password, user.HashedPassword); err != nil {
+		cfg.loginFailed(r, email)
+		renderConsent(w, req, email, "Wrong email or password.", 401)
+		return
+	}
+
+	if cfg.passwordHasher.NeedsRehash(user.HashedPassword) {
+		cfg.rehashPassword(r, user, password)
+	}
+
+	// same as loginUser, the right password alone doesn't reset the failures
+	if user.TotpEnabledAt.Valid {
+		code := r.PostForm.Get("code")
+		if code == "" {
+			renderConsent(w, req, email, "Enter the code from your authenticator app.", 401)
+			return
+		}
+		if !cfg.checkTOTPCode(r, user, code) {
+			cfg.loginFailed(r, email)
+			renderConsent(w, req, email, "Wrong 2FA code.", 401)
+			return
+		}
+	}
+	cfg.loginSucceeded(user.Email)
+
+	// 4.
+	code, err := auth.MakeRefreshToken() // same thing, 256-bit random
+	if err != nil {
+		log.Printf("%s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	scopeStrings := []string{}
+	for _, scope := range req.Scopes {
+		scopeStrings = append(scopeStrings, string(scope))
+	}
+	err = cfg.dbQueries.CreateAuthorizationCode(r.Context(), database.CreateAuthorizationCodeParams{
+		CodeHash:      auth.HashToken(code),
+		ClientID:      req.Client.ID,
+		UserID:        user.ID,
+		RedirectUri:   req.RedirectURI,
+		Scopes:        scopeStrings,
+		CodeChallenge: req.CodeChallenge,
+		ExpiresAt:     time.Now().Add(authorizationCodeLifetime),
+	})
+	if err != nil {
+		log.Printf("error creating authorization code: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	// 5.
+	redirectToClient(w, r, req, url.Values{"code": {code}})
+}
+
+// error response of the token endpoints (RFC 6749 5.2)
+func respondWithOAuthError(w http.ResponseWriter, status int, code string) {
+	resData, err := json.Marshal(map[string]string{"error": code})
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+
+	if status == 401 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(resData)
+}
+
+// the client calling /oauth/token, /oauth/introspect or /oauth/revoke.
+// client_id and client_secret from basic auth or the form.
+// confidential clients must send their secret, public clients only their id
+func (cfg *apiConfig) authenticateClient(r *http.Request) (database.OauthClient, bool) {
+	rawID, secret, ok := r.BasicAuth()
+	if !ok {
+		rawID = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	clientID, err := uuid.Parse(rawID)
+	if err != nil {
+		return database.OauthClient{}, false
+	}
+	client, err := cfg.dbQueries.GetOAuthClient(r.Context(), clientID)
+	if err != nil {
+		return database.OauthClient{}, false
+	}
+
+	if !client.SecretHash.Valid {
+		return client, secret == ""
+	}
+	secretHash := auth.HashToken(secret)
+	if subtle.ConstantTimeCompare([]byte(secretHash), []byte(client.SecretHash.String)) != 1 {
+		return database.OauthClient{}, false
+	}
+	return client, true
+}
+
+// POST /oauth/token, form encoded
+// grant_type=authorization_code: code + redirect_uri + code_verifier -> new grant
+// grant_type=refresh_token: refresh_token -> new tokens, the old refresh token dies
+func (cfg *apiConfig) oauthToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, 400, "invalid_request")
+		return
+	}
+
+	client, ok := cfg.authenticateClient(r)
+	if !ok {
+		respondWithOAuthError(w, 401, "invalid_client")
+		return
+	}
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		cfg.exchangeAuthorizationCode(w, r, client)
+	case "refresh_token":
+		cfg.refreshOAuthGrant(w, r, client)
+	default:
+		respondWithOAuthError(w, 400, "unsupported_grant_type")
+	}
+}
+
+// 1. use up the code, even if the rest fails. a code is good for one try
+// 2. it must be for this client and this redirect_uri
+// 3. PKCE: sha256 of the verifier must be the challenge from /oauth/authorize
+// 4. create the grant with its refresh token, respond with tokens
+func (cfg *apiConfig) exchangeAuthorizationCode(w http.ResponseWriter, r *http.Request, client database.OauthClient) {
+	// 1.
+	code, err := cfg.dbQueries.ConsumeAuthorizationCode(r.Context(), auth.HashToken(r.PostForm.Get("code")))
+	if err != nil {
+		respondWithOAuthError(w, 400, "invalid_grant")
+		return
+	}
+
+	// 2.
+	if code.ClientID != client.ID || code.RedirectUri != r.PostForm.Get("redirect_uri") {
+		respondWithOAuthError(w, 400, "invalid_grant")
+		return
+	}
+
+	// 3.
+	verifier := r.PostForm.Get("code_verifier")
+	if len(verifier) < 43 || len(verifier) > 128 || oidc.CodeChallenge(verifier) != code.CodeChallenge {
+		respondWithOAuthError(w, 400, "invalid_grant")
+		return
+	}
+
+	// 4.
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		log.Printf("%s", err)
+		respondWithOAuthError(w, 500, "server_error")
+		return
+	}
+
+	grant, err := cfg.dbQueries.CreateOAuthGrant(r.Context(), database.CreateOAuthGrantParams{
+		ClientID:         client.ID,
+		UserID:           code.UserID,
+		Scopes:           code.Scopes,
+		RefreshTokenHash: auth.HashToken(refreshToken),
+		RefreshExpiresAt: time.Now().Add(refreshTokenLifetime),
+	})
+	if err != nil {
+		log.Printf("error creating oauth grant: %s", err)
+		respondWithOAuthError(w, 500, "server_error")
+		return
+	}
+
+	cfg.respondWithOAuthTokens(w, grant, refreshToken)
+}
+
+// rotate the refresh token, like /api/refresh does for logins
+func (cfg *apiConfig) refreshOAuthGrant(w http.ResponseWriter, r *http.Request, client database.OauthClient) {
+	oldHash := auth.HashToken(r.PostForm.Get("refresh_token"))
+	grant, err := cfg.dbQueries.GetOAuthGrantByRefreshToken(r.Context(), oldHash)
+	if err != nil {
+		// an already rotated token: someone kept a copy, the grant isn't safe anymore
+		reused, err := cfg.dbQueries.GetOAuthGrantByPreviousRefreshToken(r.Context(), sql.NullString{String: oldHash, Valid: true})
+		if err == nil && reused.ClientID == client.ID && !reused.RevokedAt.Valid {
+			cfg.handleOAuthRefreshTokenReuse(r, reused)
+		}
+		respondWithOAuthError(w, 400, "invalid_grant")
+		return
+	}
+	if grant.ClientID != client.ID || grant.RevokedAt.Valid || grant.RefreshExpiresAt.Before(time.Now()) {
+		respondWithOAuthError(w, 400, "invalid_grant")
+		return
+	}
+
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		log.Printf("%s", err)
+		respondWithOAuthError(w, 500, "server_error")
+		return
+	}
+
+	grant.RefreshTokenHash = auth.HashToken(refreshToken)
+	grant.RefreshExpiresAt = time.Now().Add(refreshTokenLifetime)
+	rotated, err := cfg.dbQueries.RotateOAuthGrantRefreshToken(r.Context(), database.RotateOAuthGrantRefreshTokenParams{
+		NewRefreshTokenHash: grant.RefreshTokenHash,
+		RefreshExpiresAt:    grant.RefreshExpiresAt,
+		ID:                  grant.ID,
+		OldRefreshTokenHash: oldHash,
+	})
+	if err != nil {
+		log.Printf("error rotating oauth refresh token: %s", err)
+		respondWithOAuthError(w, 500, "server_error")
+		return
+	}
+
+	// lost the race against another refresh with the same token
+	if rotated == 0 {
+		respondWithOAuthError(w, 400, "invalid_grant")
+		return
+	}
+
+	cfg.respondWithOAuthTokens(w, grant, refreshToken)
+}
+
+// like handleRefreshTokenReuse for sessions: revoke the whole grant, the
+// user has to allow the app again
+func (cfg *apiConfig) handleOAuthRefreshTokenReuse(r *http.Request, grant database.OauthGrant) {
+	log.Printf("oauth refresh token reuse detected, revoking grant %s", grant.ID)
+	cfg.audit(r, audit.Event{
+		Action:  audit.ActionTokenRefreshed,
+		Outcome: audit.OutcomeFailure,
+		ActorID: grant.UserID,
+		Detail:  fmt.Sprintf("reuse of a rotated oauth refresh token, grant %s of client %s revoked", grant.ID, grant.ClientID),
+	})
+	if err := cfg.dbQueries.RevokeOAuthGrant(r.Context(), grant.ID); err != nil {
+		log.Printf("error revoking oauth grant: %s", err)
+	}
+}
+
+// RFC 6749 5.1
+func (cfg *apiConfig) respondWithOAuthTokens(w http.ResponseWriter, grant database.OauthGrant, refreshToken string) {
+	scopes := []auth.Scope{}
+	for _, scope := range grant.Scopes {
+		scopes = append(scopes, auth.Scope(scope))
+	}
+
+	accessToken, err := cfg.keyring.MakeOAuthJWT(grant.UserID, grant.ClientID, grant.ID, scopes, oauthAccessTokenLifetime)
+	if err != nil {
+		log.Printf("%s", err)
+		respondWithOAuthError(w, 500, "server_error")
+		return
+	}
+
+	type resBodyStruct struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+		Scope        string `json:"scope"`
+	}
+	resData, err := json.Marshal(resBodyStruct{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(oauthAccessTokenLifetime.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        strings.Join(grant.Scopes, " "),
+	})
+	if err != nil {
+		respondWithOAuthError(w, 500, "server_error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(200)
+	w.Write(resData)
+}
+
+// the grant behind an access or refresh token, if it was issued to this client.
+// the token type and its expiry come along for introspection
+func (cfg *apiConfig) grantForToken(r *http.Request, client database.OauthClient, token string) (database.OauthGrant, string, auth.AccessClaims, bool) {
+	if claims, err := cfg.keyring.ValidateJWT(token); err == nil {
+		if claims.ClientID != client.ID {
+			return database.OauthGrant{}, "", auth.AccessClaims{}, false
+		}
+		grant, err := cfg.dbQueries.GetOAuthGrant(r.Context(), claims.GrantID)
+		if err != nil {
+			return database.OauthGrant{}, "", auth.AccessClaims{}, false
+		}
+		return grant, "access_token", claims, true
+	}
+
+	grant, err := cfg.dbQueries.GetOAuthGrantByRefreshToken(r.Context(), auth.HashToken(token))
+	if err != nil || grant.ClientID != client.ID {
+		return database.OauthGrant{}, "", auth.AccessClaims{}, false
+	}
+	return grant, "refresh_token", auth.AccessClaims{}, true
+}
+
+// POST /oauth/introspect (RFC 7662), for the app's own backend.
+// only confidential clients, and only about their own tokens,
+// anything else is {"active": false}
+func (cfg *apiConfig) oauthIntrospect(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, 400, "invalid_request")
+		return
+	}
+
+	client, ok := cfg.authenticateClient(r)
+	if !ok || !client.SecretHash.Valid {
+		respondWithOAuthError(w, 401, "invalid_client")
+		return
+	}
+
+	type resBodyStruct struct {
+		Active    bool   `json:"active"`
+		Scope     string `json:"scope,omitempty"`
+		ClientID  string `json:"client_id,omitempty"`
+		Subject   string `json:"sub,omitempty"`
+		ExpiresAt int64  `json:"exp,omitempty"`
+		IssuedAt  int64  `json:"iat,omitempty"`
+		TokenType string `json:"token_type,omitempty"`
+	}
+	res := resBodyStruct{}
+
+	grant, tokenType, claims, ok := cfg.grantForToken(r, client, r.PostForm.Get("token"))
+	if ok && !grant.RevokedAt.Valid {
+		res = resBodyStruct{
+			Active:    true,
+			Scope:     strings.Join(grant.Scopes, " "),
+			ClientID:  grant.ClientID.String(),
+			Subject:   grant.UserID.String(),
+			TokenType: "Bearer",
+		}
+		if tokenType == "access_token" {
+			res.ExpiresAt = claims.ExpiresAt.Unix()
+			res.IssuedAt = claims.IssuedAt.Unix()
+		} else {
+			res.ExpiresAt = grant.RefreshExpiresAt.Unix()
+			res.TokenType = "refresh_token"
+		}
+		if res.ExpiresAt < time.Now().Unix() {
+			res = resBodyStruct{}
+		}
+	}
+
+	resData, err := json.Marshal(res)
+	if err != nil {
+		respondWithOAuthError(w, 500, "server_error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(200)
+	w.Write(resData)
+}
+
+// POST /oauth/revoke (RFC 7009). either token kills the whole grant,
+// the app has to send the user through /oauth/authorize again.
+// always 200, unknown tokens included, so it can't be used to probe tokens
+func (cfg *apiConfig) oauthRevoke(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		respondWithOAuthError(w, 400, "invalid_request")
+		return
+	}
+
+	client, ok := cfg.authenticateClient(r)
+	if !ok {
+		respondWithOAuthError(w, 401, "invalid_client")
+		return
+	}
+
+	grant, _, _, ok := cfg.grantForToken(r, client, r.PostForm.Get("token"))
+	if ok {
+		if err := cfg.dbQueries.RevokeOAuthGrant(r.Context(), grant.ID); err != nil {
+			log.Printf("error revoking oauth grant: %s", err)
+			respondWithOAuthError(w, 500, "server_error")
+			return
+		}
+	}
+
+	w.WriteHeader(200)
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/WaronLimsakul/Chirpy/internal/auth"
+	"github.com/WaronLimsakul/Chirpy/internal/database"
+	"github.com/WaronLimsakul/Chirpy/internal/oidc"
+	"github.com/google/uuid"
+)
+
+// users made by an OIDC login have no password, this is the column default
+const noPassword = "unset"
+
+const oauthStateLifetime = time.Minute * 10
+
+// holds the hash of the state in the browser that started the flow, the
+// callback only accepts a state that came with it. otherwise a state + code
+// from the attacker's own flow could be finished in the victim's browser:
+// logged in as the attacker, or the attacker's provider account linked to the victim
+const oauthStateCookie = "chirpy_oidc_state"
+
+// start an OIDC login: remember state + PKCE verifier + nonce, then
+// redirect the browser to the provider
+func (cfg *apiConfig) startOAuth(w http.ResponseWriter, r *http.Request) {
+	authURL, ok := cfg.beginOAuth(w, r, uuid.NullUUID{})
+	if !ok {
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// like startOAuth, but the identity gets linked to the logged in user.
+// responds with {"authorization_url"} instead of a redirect, since a browser
+// can't follow a redirect with our bearer token anyway
+func (cfg *apiConfig) linkOAuth(w http.ResponseWriter, r *http.Request) {
+	claims, err := cfg.authenticate(r, accountOnly)
+	if err != nil {
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+
+	authURL, ok := cfg.beginOAuth(w, r, uuid.NullUUID{UUID: claims.UserID, Valid: true})
+	if !ok {
+		return
+	}
+
+	type resBodyStruct struct {
+		AuthorizationURL string `json:"authorization_url"`
+	}
+	resData, err := json.Marshal(resBodyStruct{AuthorizationURL: authURL})
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(resData)
+}
+
+// save the flow state, return the provider's authorization url.
+// false = response already written
+func (cfg *apiConfig) beginOAuth(w http.ResponseWriter, r *http.Request, linkUserID uuid.NullUUID) (string, bool) {
+	provider, ok := cfg.oidcProviders[r.PathValue("provider")]
+	if !ok {
+		w.WriteHeader(404)
+		return "", false
+	}
+
+	var values [3]string // state, verifier, nonce
+	for i := range values {
+		value, err := oidc.RandomString()
+		if err != nil {
+			log.Printf("%s", err)
+			w.WriteHeader(500)
+			return "", false
+		}
+		values[i] = value
+	}
+	state, verifier, nonce := values[0], values[1], values[2]
+
+	err := cfg.dbQueries.CreateOAuthState(r.Context(), database.CreateOAuthStateParams{
+		StateHash:    auth.HashToken(state),
+		Provider:     provider.Name(),
+		CodeVerifier: verifier,
+		Nonce:        nonce,
+		UserID:       linkUserID,
+		ExpiresAt:    time.Now().Add(oauthStateLifetime),
+	})
+	if err != nil {
+		log.Printf("error saving oauth state: %s", err)
+		w.WriteHeader(500)
+		return "", false
+	}
+
+	authURL, err := provider.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		log.Printf("%s", err)
+		w.WriteHeader(502)
+		return "", false
+	}
+
+	cfg.setOAuthStateCookie(w, auth.HashToken(state), int(oauthStateLifetime.Seconds()))
+	return authURL, true
+}
+
+// maxAge < 0 deletes it
+func (cfg *apiConfig) setOAuthStateCookie(w http.ResponseWriter, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    value,
+		Path:     "/api/oauth/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(cfg.publicURL, "https://"),
+		// Lax still comes along on the provider's redirect back to us
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// the provider sends the browser back here with ?code=&state=
+// 1. use up the state, it must be for this provider and from the browser that started the flow
+// 2. exchange the code (with the PKCE verifier) for a verified ID token
+// 3. linking: add the identity to the user, respond 201 with it
+// 4. login: the user with this identity, or with the same (verified) email, or a new user.
+// Then same response as POST /api/login
+func (cfg *apiConfig) oauthCallback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := cfg.oidcProviders[r.PathValue("provider")]
+	if !ok {
+		w.WriteHeader(404)
+		return
+	}
+
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		log.Printf("oidc %s: %s %s", provider.Name(), errCode, query.Get("error_description"))
+		w.WriteHeader(401)
+		return
+	}
+
+	// 1. the state has to be from this browser, before we use it up
+	stateHash := auth.HashToken(query.Get("state"))
+	cookie, err := r.Cookie(oauthStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(stateHash)) != 1 {
+		w.WriteHeader(401)
+		return
+	}
+	cfg.setOAuthStateCookie(w, "", -1)
+
+	state, err := cfg.dbQueries.ConsumeOAuthState(r.Context(), stateHash)
+	if err != nil || state.Provider != provider.Name() {
+		w.WriteHeader(401)
+		return
+	}
+
+	// 2.
+	claims, err := provider.Exchange(r.Context(), query.Get("code"), state.CodeVerifier, state.Nonce)
+	if err != nil {
+		log.Printf("oidc %s: %s", provider.Name(), err)
+		w.WriteHeader(401)
+		return
+	}
+
+	// 3.
+	if state.UserID.Valid {
+		cfg.linkIdentity(w, r, state.UserID.UUID, provider.Name(), claims)
+		return
+	}
+
+	// 4.
+	user, ok := cfg.userForIdentity(w, r, provider.Name(), claims)
+	if !ok {
+		return
+	}
+
+	if user.TotpEnabledAt.Valid {
+		cfg.respondWithTwoFactorChallenge(w, user)
+		return
+	}
+
+	cfg.respondWithLogin(w, r, user)
+}
+
+func (cfg *apiConfig) linkIdentity(w http.ResponseWriter, r *http.Request, userID uuid.UUID, provider string, claims oidc.Claims) {
+	existing, err := cfg.dbQueries.GetUserIdentity(r.Context(), database.GetUserIdentityParams{
+		Provider: provider,
+		Subject:  claims.Subject,
+	})
+	if err == nil && existing.UserID != userID {
+		w.WriteHeader(409) // linked to someone else
+		return
+	}
+
+	identity := existing
+	if err != nil {
+		identity, err = cfg.dbQueries.CreateUserIdentity(r.Context(), database.CreateUserIdentityParams{
+			UserID:   userID,
+			Provider: provider,
+			Subject:  claims.Subject,
+			Email:    claims.Email,
+		})
+		if err != nil {
+			// most likely the user already has another account of this provider
+			log.Printf("error linking identity: %s", err)
+			w.WriteHeader(409)
+			return
+		}
+	}
+
+	resData, err := json.Marshal(toIdentity(identity))
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(201)
+	w.Write(resData)
+}
+
+// find or make the user an OIDC login is for. false = response already written
+// NOTE: an existing account is only linked by email if both the provider and
+// we have the email verified (see oidc.CanLinkByEmail). otherwise 409, the user
+// logs in with their password and links the identity with POST /api/oauth/{provider}/link
+func (cfg *apiConfig) userForIdentity(w http.ResponseWriter, r *http.Request, provider string, claims oidc.Claims) (database.User, bool) {
+	identity, err := cfg.dbQueries.GetUserIdentity(r.Context(), database.GetUserIdentityParams{
+		Provider: provider,
+		Subject:  claims.Subject,
+	})
+	if err == nil {
+		user, err := cfg.dbQueries.GetUserByID(r.Context(), identity.UserID)
+		if err != nil {
+			log.Printf("%s", err)
+			w.WriteHeader(500)
+			return database.User{}, false
+		}
+		return user, true
+	}
+
+	if claims.Email == "" {
+		log.Printf("oidc %s: id token has no email, add the email scope", provider)
+		w.WriteHeader(401)
+		return database.User{}, false
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("%s", err)
+		w.WriteHeader(500)
+		return database.User{}, false
+	}
+	defer tx.Rollback() // no-op after commit
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	user, err := qtx.GetUserByEmail(r.Context(), claims.Email)
+	isNew := false
+	if err == nil {
+		if !oidc.CanLinkByEmail(claims, user.EmailVerifiedAt.Valid) {
+			w.WriteHeader(409)
+			return database.User{}, false
+		}
+	} else {
+		verifiedAt := sql.NullTime{}
+		if claims.EmailVerified {
+			verifiedAt = sql.NullTime{Time: time.Now(), Valid: true}
+		}
+		user, err = qtx.CreateExternalUser(r.Context(), database.CreateExternalUserParams{
+			Email:           claims.Email,
+			EmailVerifiedAt: verifiedAt,
+		})
+		if err != nil {
+			log.Printf("error creating user for oidc login: %s", err)
+			w.WriteHeader(409)
+			return database.User{}, false
+		}
+		isNew = true
+	}
+
+	_, err = qtx.CreateUserIdentity(r.Context(), database.CreateUserIdentityParams{
+		UserID:   user.ID,
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+	})
+	if err != nil {
+		// e.g. the user already has another account of this provider
+		log.Printf("error linking identity: %s", err)
+		w.WriteHeader(409)
+		return database.User{}, false
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("%s", err)
+		w.WriteHeader(500)
+		return database.User{}, false
+	}
+
+	if isNew && !user.EmailVerifiedAt.Valid {
+		if err := cfg.sendEmailVerification(r.Context(), user.ID, user.Email); err != nil {
+			log.Printf("error sending email verification: %s", err)
+		}
+	}
+
+	return user, true
+}
+
+// database.UserIdentity -> Identity
+func toIdentity(identity database.UserIdentity) Identity {
+	return Identity{
+		ID:        identity.ID,
+		Provider:  identity.Provider,
+		Email:     identity.Email,
+		CreatedAt: identity.CreatedAt,
+	}
+}
+
+// identities linked to the user
+func (cfg *apiConfig) listIdentities(w http.ResponseWriter, r *http.Request) {
+	claims, err := cfg.authenticate(r, accountOnly)
+	if err != nil {
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+
+	identities, err := cfg.dbQueries.ListUserIdentities(r.Context(), claims.UserID)
+	if err != nil {
+		log.Printf("error listing identities: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	resIdentities := []Identity{}
+	for _, identity := range identities {
+		resIdentities = append(resIdentities, toIdentity(identity))
+	}
+
+	resData, err := json.Marshal(resIdentities)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(resData)
+}
+
+// unlink an identity. 409 if it's the only way into an account without password
+func (cfg *apiConfig) unlinkIdentity(w http.ResponseWriter, r *http.Request) {
+	claims, err := cfg.authenticate(r, accountOnly)
+	if err != nil {
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+
+	identityID, err := uuid.Parse(r.PathValue("identity_id"))
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), claims.UserID)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+
+	if user.HashedPassword == noPassword {
+		identities, err := cfg.dbQueries.ListUserIdentities(r.Context(), user.ID)
+		if err != nil {
+			log.Printf("error listing identities: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+		if len(identities) <= 1 {
+			w.WriteHeader(409)
+			return
+		}
+	}
+
+	deleted, err := cfg.dbQueries.DeleteUserIdentity(r.Context(), database.DeleteUserIdentityParams{
+		ID:     identityID,
+		UserID: user.ID,
+	})
+	if err != nil {
+		log.Printf("error unlinking identity: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if deleted == 0 {
+		w.WriteHeader(404)
+		return
+	}
+
+	w.WriteHeader(204)
+}
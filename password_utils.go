@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/WaronLimsakul/Chirpy/internal/audit"
+	"github.com/WaronLimsakul/Chirpy/internal/auth"
+	"github.com/WaronLimsakul/Chirpy/internal/database"
+	"github.com/WaronLimsakul/Chirpy/internal/mailer"
+)
+
+const passwordResetLifetime = time.Minute * 30
+
+// send a password reset token to "email" in body
+// - always 202 right away, everything that depends on the account (token,
+// db rows, the mail) happens in the background, so neither the status nor
+// the response time tells who has an account
+// - a few mails per email, then throttled (see mailThrottled), still 202
+// - asking again kills the previous token
+func (cfg *apiConfig) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	type reqBodyStruct struct {
+		Email string `json:"email"`
+	}
+
+	var req reqBodyStruct
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	if !cfg.mailThrottled("password-reset", req.Email) {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+			defer cancel()
+			if err := cfg.mailPasswordReset(ctx, req.Email); err != nil {
+				log.Printf("error sending password reset: %s", err)
+			}
+		}()
+	}
+
+	w.WriteHeader(202)
+}
+
+// the background part of forgotPassword. no account = nothing to do
+func (cfg *apiConfig) mailPasswordReset(ctx context.Context, email string) error {
+	user, err := cfg.dbQueries.GetUserByEmail(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	resetToken, err := auth.MakeRefreshToken() // same thing, 256-bit random
+	if err != nil {
+		return err
+	}
+
+	if err := cfg.dbQueries.InvalidateUserPasswordResets(ctx, user.ID); err != nil {
+		return fmt.Errorf("invalidating password resets: %w", err)
+	}
+
+	resetParams := database.CreatePasswordResetParams{
+		TokenHash: auth.HashToken(resetToken),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(passwordResetLifetime),
+	}
+	if err := cfg.dbQueries.CreatePasswordReset(ctx, resetParams); err != nil {
+		return fmt.Errorf("creating password reset: %w", err)
+	}
+
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your Chirpy account.\n\n"+
+			"Your reset token: %s\n"+
+			"Send it with your new password to POST %s/api/password/reset\n\n"+
+			"It expires in %d minutes. If it wasn't you, just ignore this email.",
+			resetToken, cfg.publicURL, int(passwordResetLifetime.Minutes())),
+	}
+	return cfg.mailer.Send(ctx, msg)
+}
+
+// true if this email got too many mails of this kind lately (mail bombing
+// someone through our endpoints). counted per email whether it has an account
+// or not, and callers still answer 202, so it doesn't tell either
+func (cfg *apiConfig) mailThrottled(kind, email string) bool {
+	key := kind + ":" + loginAccountKey(email)
+	now := time.Now()
+	if wait := cfg.mailLimiter.Wait(key, now); wait > 0 {
+		log.Printf("%s mail to %q throttled for %s", kind, email, wait)
+		return true
+	}
+	cfg.mailLimiter.Fail(key, now)
+	return false
+}
+
+// body has "token" and "password"
+// 1. use up the reset token (single-use, expiring)
+// 2. set the new password
+// 3. revoke all refresh tokens, whoever had them should log in again
+// all in one transaction
+func (cfg *apiConfig) resetPassword(w http.ResponseWriter, r *http.Request) {
+	type reqBodyStruct struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	var req reqBodyStruct
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	if !cfg.checkNewPassword(w, req.Password) {
+		return
+	}
+
+	hashedPassword, err := cfg.passwordHasher.Hash(req.Password)
+	if err != nil {
+		log.Printf("error hashing password at resetPassword: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("%s", err)
+		w.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	// 1.
+	userID, err := qtx.ConsumePasswordReset(r.Context(), auth.HashToken(req.Token))
+	if err != nil {
+		// not found, used or expired
+		cfg.audit(r, audit.Event{
+			Action:  audit.ActionPasswordReset,
+			Outcome: audit.OutcomeFailure,
+			Detail:  "invalid reset token",
+		})
+		w.WriteHeader(401)
+		return
+	}
+
+	// 2.
+	updateParams := database.UpdateUserPasswordParams{
+		HashedPassword: hashedPassword,
+		ID:             userID,
+	}
+	if err := qtx.UpdateUserPassword(r.Context(), updateParams); err != nil {
+		log.Printf("error updating password: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	// 3.
+	if err := qtx.RevokeAllUserTokens(r.Context(), userID); err != nil {
+		log.Printf("error revoking refresh tokens: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("%s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	cfg.audit(r, audit.Event{Action: audit.ActionPasswordReset, ActorID: userID})
+
+	w.WriteHeader(204)
+}
+
+// true if the new password passes the policy.
+// otherwise write 400 with every violation, so the client can show them all at once:
+// {"error": "...", "violations": [{"code": "too_short", "message": "..."}]}
+func (cfg *apiConfig) checkNewPassword(w http.ResponseWriter, password string) bool {
+	violations := cfg.passwordPolicy.Check(password)
+	if len(violations) == 0 {
+		return true
+	}
+
+	type resBodyStruct struct {
+		Error      string                   `json:"error"`
+		Violations []auth.PasswordViolation `json:"violations"`
+	}
+	resData, err := json.Marshal(resBodyStruct{
+		Error:      "password rejected",
+		Violations: violations,
+	})
+	if err != nil {
+		w.WriteHeader(500)
+		return false
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(400)
+	w.Write(resData)
+	return false
+}
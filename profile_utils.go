@@ -0,0 +1,300 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/WaronLimsakul/Chirpy/internal/audit"
+	"github.com/WaronLimsakul/Chirpy/internal/auth"
+	"github.com/WaronLimsakul/Chirpy/internal/database"
+	"github.com/WaronLimsakul/Chirpy/internal/profile"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// database.User -> PublicUser, what anyone may see about a user
+func toPublicUser(user database.User) PublicUser {
+	return PublicUser{
+		ID:          user.ID,
+		CreatedAt:   user.CreatedAt,
+		IsChirpyRed: user.IsChirpyRed,
+		Handle:      user.Handle.String,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		Location:    user.Location,
+		Website:     user.Website,
+	}
+}
+
+// toPublicUser with the follow counts, as the response
+func (cfg *apiConfig) writePublicUser(w http.ResponseWriter, r *http.Request, user database.User) {
+	counts, err := cfg.dbQueries.CountFollows(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("error counting follows: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	res := toPublicUser(user)
+	res.Followers = counts.Followers
+	res.Following = counts.Following
+
+	resData, err := json.Marshal(res)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(resData)
+}
+
+// public profile of any user, no token needed
+func (cfg *apiConfig) getUserProfile(w http.ResponseWriter, r *http.Request) {
+	userID, err := uuid.Parse(r.PathValue("user_id"))
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	cfg.writePublicUser(w, r, user)
+}
+
+// public profile by @handle, case doesn't matter and the "@" is optional
+func (cfg *apiConfig) getUserByHandle(w http.ResponseWriter, r *http.Request) {
+	handle := profile.NormalizeHandle(r.PathValue("handle"))
+	if profile.ValidateHandle(handle) != nil {
+		// reserved or malformed, nobody can have it
+		w.WriteHeader(404)
+		return
+	}
+
+	user, err := cfg.dbQueries.GetUserByHandle(r.Context(), handle)
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	cfg.writePublicUser(w, r, user)
+}
+
+// set the public profile. body has any of "handle", "display_name", "bio",
+// "location" and "website", missing ones stay as they are. "handle": "" removes it
+// - 400 with {"error": "..."} if a field is invalid
+// - 409 if someone else has the handle (in any case)
+func (cfg *apiConfig) updateProfile(w http.ResponseWriter, r *http.Request) {
+	claims, err := cfg.authenticate(r, accountOnly)
+	if err != nil {
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+
+	type reqBodyStruct struct {
+		Handle      *string `json:"handle"`
+		DisplayName *string `json:"display_name"`
+		Bio         *string `json:"bio"`
+		Location    *string `json:"location"`
+		Website     *string `json:"website"`
+	}
+	req := reqBodyStruct{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), claims.UserID)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+
+	params := database.UpdateUserProfileParams{
+		Handle:      user.Handle,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		Location:    user.Location,
+		Website:     user.Website,
+		ID:          user.ID,
+	}
+	set := func(dst *string, src *string) {
+		if src != nil {
+			*dst = strings.TrimSpace(*src)
+		}
+	}
+	set(&params.DisplayName, req.DisplayName)
+	set(&params.Bio, req.Bio)
+	set(&params.Location, req.Location)
+	set(&params.Website, req.Website)
+
+	fields := profile.Fields{
+		DisplayName: params.DisplayName,
+		Bio:         params.Bio,
+		Location:    params.Location,
+		Website:     params.Website,
+	}
+	if err := fields.Validate(); err != nil {
+		writeProfileError(w, err)
+		return
+	}
+
+	if req.Handle != nil {
+		handle := profile.NormalizeHandle(*req.Handle)
+		params.Handle = sql.NullString{String: handle, Valid: handle != ""}
+	}
+	// only check a changed handle, a now reserved one can be kept
+	if params.Handle.Valid && !strings.EqualFold(params.Handle.String, user.Handle.String) {
+		if err := profile.ValidateHandle(params.Handle.String); err != nil {
+			writeProfileError(w, err)
+			return
+		}
+
+		other, err := cfg.dbQueries.GetUserByHandle(r.Context(), params.Handle.String)
+		if err == nil && other.ID != user.ID {
+			w.WriteHeader(409)
+			return
+		}
+	}
+
+	updated, err := cfg.dbQueries.UpdateUserProfile(r.Context(), params)
+	if err != nil {
+		if isHandleTaken(err) {
+			// someone took the handle since we checked
+			w.WriteHeader(409)
+			return
+		}
+		log.Printf("error updating profile: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	resData, err := json.Marshal(toUser(updated))
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(resData)
+}
+
+// unique_violation on users_handle_lower_idx, other errors aren't the user's fault
+func isHandleTaken(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "users_handle_lower_idx"
+}
+
+func writeProfileError(w http.ResponseWriter, err error) {
+	resData, marshalErr := json.Marshal(map[string]string{"error": err.Error()})
+	if marshalErr != nil {
+		w.WriteHeader(500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(400)
+	w.Write(resData)
+}
+
+// the user in the access token, with the private fields (email...)
+func (cfg *apiConfig) getMe(w http.ResponseWriter, r *http.Request) {
+	claims, err := cfg.authenticate(r, accountOnly)
+	if err != nil {
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), claims.UserID)
+	if err != nil {
+		// token outlived the account
+		w.WriteHeader(401)
+		return
+	}
+
+	resData, err := json.Marshal(toUser(user))
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(resData)
+}
+
+// delete your own account, with all chirps, tokens, sessions... (db cascades).
+// chirps with replies stay as tombstones without an author
+// body has "password", and "code" (TOTP) if 2FA is on.
+// a stolen access token alone shouldn't be enough to wipe an account,
+// so it's checked (and throttled) like a login
+func (cfg *apiConfig) deleteMe(w http.ResponseWriter, r *http.Request) {
+	claims, err := cfg.authenticate(r, accountOnly)
+	if err != nil {
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+
+	type reqBodyStruct struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	req := reqBodyStruct{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), claims.UserID)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+
+	// signed up with OIDC only, they can set one with the password reset
+	if user.HashedPassword == noPassword {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(403)
+		w.Write([]byte("set a password first"))
+		return
+	}
+
+	if cfg.loginThrottled(w, r, user.Email) {
+		return
+	}
+
+	if err := auth.CheckPasswordHash(req.Password, user.HashedPassword); err != nil {
+		cfg.loginFailed(r, user.Email)
+		w.WriteHeader(403)
+		return
+	}
+
+	if user.TotpEnabledAt.Valid && !cfg.checkTOTPCode(r, user, req.Code) {
+		cfg.loginFailed(r, user.Email)
+		w.WriteHeader(403)
+		return
+	}
+
+	deleted, err := cfg.deleteUserKeepingThreads(r.Context(), user.ID)
+	if err != nil {
+		log.Printf("error deleting user: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if deleted == 0 {
+		w.WriteHeader(404)
+		return
+	}
+	cfg.loginSucceeded(user.Email)
+	cfg.audit(r, audit.Event{Action: audit.ActionAccountDeleted, ActorID: user.ID})
+
+	w.WriteHeader(204)
+}
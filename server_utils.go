@@ -1,10 +1,15 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
+	"strings"
 
+	"github.com/WaronLimsakul/Chirpy/internal/audit"
+	"github.com/WaronLimsakul/Chirpy/internal/auth"
 	_ "github.com/lib/pq"
 )
 
@@ -36,28 +41,66 @@ func (cfg *apiConfig) reportFileServerHits(w http.ResponseWriter, req *http.Requ
 	w.Write(body)
 }
 
+// IP of the client, without the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// record an audit event with the request's IP and user agent
+func (cfg *apiConfig) audit(r *http.Request, event audit.Event) {
+	event.IP = clientIP(r)
+	event.UserAgent = r.UserAgent()
+	cfg.auditLog.Record(r.Context(), event)
+}
+
+// cut s to at most n bytes, for user-supplied strings we store
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	// don't leave half a utf-8 character at the end, postgres rejects it
+	return strings.ToValidUTF8(s[:n], "")
+}
+
+// POST /admin/reset wipes everything, so it needs an admin like the rest of /admin/.
+// test scripts that reset an empty server (no admin yet) can turn that off with
+// ALLOW_UNAUTHENTICATED_RESET=true, only on PLATFORM=dev
+func (cfg *apiConfig) resetHandler() http.HandlerFunc {
+	if cfg.platform == "dev" && cfg.allowOpenReset {
+		log.Println("WARNING: ALLOW_UNAUTHENTICATED_RESET is on, anyone can reset this server")
+		return cfg.resetServer
+	}
+	return cfg.requireRole(auth.RoleAdmin, cfg.resetServer)
+}
+
 // 0. check if server platform is 'dev'
 // 1. reset file server hits
 // 2. reset users data
 func (cfg *apiConfig) resetServer(w http.ResponseWriter, req *http.Request) {
 	if cfg.platform != "dev" {
 		log.Println("not development env")
+		cfg.audit(req, audit.Event{Action: audit.ActionServerReset, Outcome: audit.OutcomeFailure, Detail: "not a dev platform"})
 		w.WriteHeader(403)
 		return
 	}
 
 	cfg.fileServerHits.Store(0)
 
-	err := cfg.dbQueries.ResetUser(req.Context())
+	// chirps first, tombstones without an author don't go with the users
+	err := cfg.dbQueries.ResetChirp(req.Context())
 	if err != nil {
-		log.Println("error reseting user data")
+		log.Println("error reseting chirp data")
 		w.WriteHeader(500)
 		return
 	}
 
-	err = cfg.dbQueries.ResetChirp(req.Context())
+	err = cfg.dbQueries.ResetUser(req.Context())
 	if err != nil {
-		log.Println("error reseting chirp data")
+		log.Println("error reseting user data")
 		w.WriteHeader(500)
 		return
 	}
@@ -69,6 +112,127 @@ func (cfg *apiConfig) resetServer(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	cfg.audit(req, audit.Event{Action: audit.ActionServerReset})
+
 	w.WriteHeader(200)
 	w.Write([]byte("Server reset"))
 }
+
+// rotate the access token signing key without a restart
+// 0. behind requireRole(admin)
+// 1. optional body: {"kid", "secret"} for HS256, {"kid", "private_key_pem"} for RS256/EdDSA
+// 2. without a key, generate a random one of the same algorithm as the active key
+// 3. old key keeps validating tokens until its grace period ends
+// NOTE: rotated keys live in memory, put them in env to survive a restart.
+// a generated key comes back in the response (secret or private_key_pem) for that
+func (cfg *apiConfig) rotateSigningKey(w http.ResponseWriter, req *http.Request) {
+	type reqBodyStruct struct {
+		KeyID         string `json:"kid"`
+		Secret        string `json:"secret"`
+		PrivateKeyPEM string `json:"private_key_pem"`
+	}
+
+	reqBody := reqBodyStruct{}
+	if req.ContentLength != 0 {
+		decoder := json.NewDecoder(req.Body)
+		if err := decoder.Decode(&reqBody); err != nil {
+			log.Printf("error decoding body in rotateSigningKey: %s", err)
+			w.WriteHeader(400)
+			return
+		}
+	}
+
+	var err error
+	if reqBody.KeyID == "" {
+		reqBody.KeyID, err = auth.MakeKeyID()
+		if err != nil {
+			log.Printf("%s", err)
+			w.WriteHeader(500)
+			return
+		}
+	}
+
+	var newKey *auth.SigningKey
+	generatedSecret := ""
+	generatedPEM := ""
+	switch {
+	case reqBody.PrivateKeyPEM != "":
+		newKey, err = auth.NewPEMKey(reqBody.KeyID, []byte(reqBody.PrivateKeyPEM))
+	case reqBody.Secret != "":
+		newKey, err = auth.NewHMACKey(reqBody.KeyID, []byte(reqBody.Secret))
+	case cfg.keyring.ActiveKey().Algorithm() == "HS256":
+		// generate it here so we can give it back, it has to go in env
+		generatedSecret, err = auth.MakeRefreshToken() // 256-bit random is good for HS256 too
+		if err == nil {
+			newKey, err = auth.NewHMACKey(reqBody.KeyID, []byte(generatedSecret))
+		}
+	default:
+		// same here, the private key has to go in the PEM config
+		newKey, err = auth.GenerateKeyLike(reqBody.KeyID, cfg.keyring.ActiveKey())
+		if err == nil {
+			var pemData []byte
+			pemData, err = newKey.PrivateKeyPEM()
+			generatedPEM = string(pemData)
+		}
+	}
+	if err != nil {
+		log.Printf("error making signing key: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	retiredKeyID, err := cfg.keyring.Rotate(newKey)
+	if err != nil {
+		log.Printf("error rotating signing key: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	claims, _ := claimsFromContext(req.Context())
+	cfg.audit(req, audit.Event{
+		Action:  audit.ActionSigningKeyRotate,
+		ActorID: claims.UserID,
+		Detail:  fmt.Sprintf("new kid %s (%s), retired kid %s", newKey.ID(), newKey.Algorithm(), retiredKeyID),
+	})
+
+	type resBodyStruct struct {
+		KeyID        string `json:"kid"`
+		Algorithm    string `json:"alg"`
+		RetiredKeyID string `json:"retired_kid"`
+		// only when we made it, so it can go in env / the PEM config
+		Secret        string `json:"secret,omitempty"`
+		PrivateKeyPEM string `json:"private_key_pem,omitempty"`
+	}
+
+	res := resBodyStruct{
+		KeyID:         newKey.ID(),
+		Algorithm:     newKey.Algorithm(),
+		RetiredKeyID:  retiredKeyID,
+		Secret:        generatedSecret,
+		PrivateKeyPEM: generatedPEM,
+	}
+	resData, err := json.Marshal(res)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(resData)
+}
+
+// publish public keys of RS256/EdDSA signing keys
+// (HS256 secrets are never here, so it's empty in HMAC mode)
+func (cfg *apiConfig) getJWKS(w http.ResponseWriter, req *http.Request) {
+	resData, err := json.Marshal(cfg.keyring.JWKS())
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	// short cache, clients should refetch on unknown kid anyway
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.WriteHeader(200)
+	w.Write(resData)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/WaronLimsakul/Chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// list active sessions (refresh token families) of the user in the access token
+func (cfg *apiConfig) listSessions(w http.ResponseWriter, r *http.Request) {
+	claims, err := cfg.authenticate(r, accountOnly)
+	if err != nil {
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+	userID := claims.UserID
+
+	sessions, err := cfg.dbQueries.ListUserSessions(r.Context(), userID)
+	if err != nil {
+		log.Printf("error listing sessions: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	resSessions := []Session{}
+	for _, session := range sessions {
+		resSessions = append(resSessions, Session{
+			ID:         session.FamilyID,
+			StartedAt:  session.StartedAt,
+			LastUsedAt: session.LastUsedAt,
+			ExpiresAt:  session.ExpiresAt,
+			UserAgent:  session.UserAgent,
+			IPAddress:  session.IpAddress,
+		})
+	}
+
+	resData, err := json.Marshal(resSessions)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(resData)
+}
+
+// revoke one session of the user, 404 if it's not theirs or already gone
+// NOTE: access tokens already issued still work until they expire (1 hour)
+func (cfg *apiConfig) revokeSession(w http.ResponseWriter, r *http.Request) {
+	claims, err := cfg.authenticate(r, accountOnly)
+	if err != nil {
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+	userID := claims.UserID
+
+	sessionID, err := uuid.Parse(r.PathValue("session_id"))
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	params := database.RevokeUserSessionParams{
+		FamilyID: sessionID,
+		UserID:   userID,
+	}
+	revoked, err := cfg.dbQueries.RevokeUserSession(r.Context(), params)
+	if err != nil {
+		log.Printf("error revoking session: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if revoked == 0 {
+		w.WriteHeader(404)
+		return
+	}
+
+	w.WriteHeader(204)
+}
+
+// log out everywhere = revoke every refresh token of the user
+func (cfg *apiConfig) revokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	claims, err := cfg.authenticate(r, accountOnly)
+	if err != nil {
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+	userID := claims.UserID
+
+	err = cfg.dbQueries.RevokeAllUserTokens(r.Context(), userID)
+	if err != nil {
+		log.Printf("error revoking all sessions: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(204)
+}
@@ -0,0 +1,25 @@
+-- name: InsertAuditEvent :exec
+INSERT INTO audit_events (created_at, action, outcome, actor_id, target_id, ip_address, user_agent, detail)
+VALUES (
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7
+);
+
+-- name: ListAuditEvents :many
+-- newest first, every filter is optional. user_id matches actor or target
+SELECT * FROM audit_events
+WHERE (sqlc.narg(user_id)::uuid IS NULL OR actor_id = sqlc.narg(user_id) OR target_id = sqlc.narg(user_id))
+    AND (sqlc.narg(action)::text IS NULL OR action = sqlc.narg(action))
+    AND (sqlc.narg(outcome)::text IS NULL OR outcome = sqlc.narg(outcome))
+    AND (sqlc.narg(ip_address)::text IS NULL OR ip_address = sqlc.narg(ip_address))
+    AND (sqlc.narg(since)::timestamp IS NULL OR created_at >= sqlc.narg(since))
+    AND (sqlc.narg(until)::timestamp IS NULL OR created_at < sqlc.narg(until))
+    AND (sqlc.narg(before_id)::bigint IS NULL OR id < sqlc.narg(before_id))
+ORDER BY id DESC
+LIMIT sqlc.arg(max_results);
@@ -0,0 +1,13 @@
+-- name: RecordChirpPost :exec
+INSERT INTO chirp_posts (user_id, posted_at)
+VALUES ($1, NOW());
+
+-- name: CountChirpPostsSince :one
+-- for the posting rate limit, deleted chirps count too
+SELECT COUNT(*) FROM chirp_posts
+WHERE user_id = $1 AND posted_at > $2;
+
+-- name: PruneChirpPosts :exec
+-- only the last hour is ever counted
+DELETE FROM chirp_posts
+WHERE user_id = $1 AND posted_at <= $2;
@@ -1,28 +1,126 @@
 -- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+-- publish_at NULL = right now
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, publish_at, in_reply_to)
 VALUES (
     gen_random_uuid(),
     NOW(),
     NOW(),
     $1,
-    $2
+    sqlc.arg(user_id)::uuid,
+    COALESCE(sqlc.narg(publish_at), NOW()),
+    sqlc.narg(in_reply_to)
 ) RETURNING *;
 
 -- name: ResetChirp :exec
 DELETE FROM chirps;
 
 -- name: GetAllChirps :many
+-- published only, no tombstones
 SELECT * FROM chirps
-ORDER BY created_at ASC;
+WHERE publish_at <= NOW() AND deleted_at IS NULL
+ORDER BY publish_at ASC;
 
 -- name: GetChirpByID :one
+-- scheduled ones and tombstones too, callers check publish_at and deleted_at
 SELECT * FROM chirps
 WHERE id = $1;
 
+-- name: LockChirp :one
+-- GetChirpByID that holds the row until the tx ends. new replies wait for it too
+-- (their foreign key check locks the parent), so HasReplies stays true
+SELECT * FROM chirps
+WHERE id = $1
+FOR UPDATE;
+
 -- name: DeleteChirpByID :exec
 DELETE FROM chirps
 WHERE id = $1;
 
 -- name: GetChirpByAuthorID :many
+-- published only, no tombstones
+SELECT * FROM chirps
+WHERE user_id = sqlc.arg(user_id)::uuid AND publish_at <= NOW() AND deleted_at IS NULL;
+
+-- name: ListScheduledChirps :many
 SELECT * FROM chirps
-WHERE user_id = $1;
+WHERE user_id = sqlc.arg(user_id)::uuid AND publish_at > NOW() AND deleted_at IS NULL
+ORDER BY publish_at ASC;
+
+-- name: UpdateChirpBody :one
+UPDATE chirps
+SET body = $1, updated_at = NOW()
+WHERE id = $2
+RETURNING *;
+
+-- name: TombstoneChirp :exec
+-- delete a chirp that has replies, what's left only holds the thread together
+UPDATE chirps
+SET body = '', deleted_at = NOW(), updated_at = NOW()
+WHERE id = $1;
+
+-- name: LockUserChirps :many
+-- LockChirp for all chirps of a user, before deleting the account
+SELECT id FROM chirps
+WHERE user_id = sqlc.arg(user_id)::uuid
+FOR UPDATE;
+
+-- name: TombstoneUserChirps :exec
+-- before deleting an account: its chirps with replies stay as tombstones
+-- without an author, so the cascade leaves them alone
+UPDATE chirps
+SET body = '', user_id = NULL, deleted_at = COALESCE(deleted_at, NOW()), updated_at = NOW()
+WHERE user_id = sqlc.arg(user_id)::uuid
+    AND EXISTS (SELECT 1 FROM chirps reply WHERE reply.in_reply_to = chirps.id);
+
+-- name: HasReplies :one
+-- any reply, scheduled and tombstones too
+SELECT EXISTS (SELECT 1 FROM chirps WHERE in_reply_to = $1);
+
+-- name: CountReplies :many
+-- direct replies that anyone can see, per chirp of a page
+SELECT in_reply_to, COUNT(*) AS replies FROM chirps
+WHERE in_reply_to = ANY(sqlc.arg(ids)::uuid[]) AND publish_at <= NOW() AND deleted_at IS NULL
+GROUP BY in_reply_to;
+
+-- name: GetChirpAncestors :many
+-- the chirps above id in its thread, up to max_depth of them, the root (or the farthest one) first
+WITH RECURSIVE ancestors AS (
+    SELECT parent.id, parent.created_at, parent.updated_at, parent.body, parent.user_id, parent.publish_at, parent.in_reply_to, parent.deleted_at, 1 AS depth FROM chirps parent
+    WHERE parent.id = (SELECT in_reply_to FROM chirps WHERE chirps.id = sqlc.arg(id))
+    UNION ALL
+    SELECT parent.id, parent.created_at, parent.updated_at, parent.body, parent.user_id, parent.publish_at, parent.in_reply_to, parent.deleted_at, ancestors.depth + 1 FROM chirps parent
+    JOIN ancestors ON parent.id = ancestors.in_reply_to
+    WHERE ancestors.depth < sqlc.arg(max_depth)::int
+)
+SELECT id, created_at, updated_at, body, user_id, publish_at, in_reply_to, deleted_at FROM ancestors
+ORDER BY depth DESC;
+
+-- name: GetChirpReplies :many
+-- a page of direct replies to parent_id (oldest first, after the cursor), each
+-- with its replies down to max_depth levels, the first max_children of them per
+-- chirp (the rest are in reply_count, and in that chirp's own thread), and
+-- max_total in all: the recursion goes a level at a time and stops there, so
+-- the deepest levels are the ones cut. published ones only, tombstones too
+WITH RECURSIVE tree AS (
+    SELECT top.id, top.created_at, top.updated_at, top.body, top.user_id, top.publish_at, top.in_reply_to, top.deleted_at, 1 AS depth FROM (
+        SELECT id, created_at, updated_at, body, user_id, publish_at, in_reply_to, deleted_at FROM chirps
+        WHERE chirps.in_reply_to = sqlc.arg(parent_id) AND chirps.publish_at <= NOW()
+            AND (sqlc.narg(after_publish_at)::timestamp IS NULL
+                OR (chirps.publish_at, chirps.id) > (sqlc.narg(after_publish_at)::timestamp, sqlc.narg(after_id)::uuid))
+        ORDER BY chirps.publish_at, chirps.id
+        LIMIT sqlc.arg(max_results)
+    ) top
+    UNION ALL
+    SELECT reply.id, reply.created_at, reply.updated_at, reply.body, reply.user_id, reply.publish_at, reply.in_reply_to, reply.deleted_at, tree.depth + 1 FROM tree
+    CROSS JOIN LATERAL (
+        SELECT id, created_at, updated_at, body, user_id, publish_at, in_reply_to, deleted_at FROM chirps
+        WHERE chirps.in_reply_to = tree.id AND chirps.publish_at <= NOW()
+        ORDER BY chirps.publish_at, chirps.id
+        LIMIT sqlc.arg(max_children)::int
+    ) reply
+    WHERE tree.depth < sqlc.arg(max_depth)::int
+)
+SELECT id, created_at, updated_at, body, user_id, publish_at, in_reply_to, deleted_at, depth FROM (
+    SELECT * FROM tree LIMIT sqlc.arg(max_total)::int
+) capped
+ORDER BY depth, publish_at, id;
@@ -0,0 +1,38 @@
+-- name: FollowUser :execrows
+-- 0 rows = already following
+INSERT INTO follows (follower_id, followee_id, created_at)
+VALUES (
+    $1,
+    $2,
+    NOW()
+)
+ON CONFLICT (follower_id, followee_id) DO NOTHING;
+
+-- name: UnfollowUser :execrows
+DELETE FROM follows
+WHERE follower_id = $1 AND followee_id = $2;
+
+-- name: ListFollowers :many
+-- newest follow first, before_id is the cursor (the id of the last one seen)
+SELECT follows.id, follows.created_at, users.id AS user_id, users.handle, users.display_name, user_is_chirpy_red(users.id) AS is_chirpy_red
+FROM follows
+JOIN users ON users.id = follows.follower_id
+WHERE follows.followee_id = sqlc.arg(user_id)
+    AND (sqlc.narg(before_id)::bigint IS NULL OR follows.id < sqlc.narg(before_id))
+ORDER BY follows.id DESC
+LIMIT sqlc.arg(max_results);
+
+-- name: ListFollowing :many
+-- same as ListFollowers the other way around
+SELECT follows.id, follows.created_at, users.id AS user_id, users.handle, users.display_name, user_is_chirpy_red(users.id) AS is_chirpy_red
+FROM follows
+JOIN users ON users.id = follows.followee_id
+WHERE follows.follower_id = sqlc.arg(user_id)
+    AND (sqlc.narg(before_id)::bigint IS NULL OR follows.id < sqlc.narg(before_id))
+ORDER BY follows.id DESC
+LIMIT sqlc.arg(max_results);
+
+-- name: CountFollows :one
+SELECT
+    (SELECT COUNT(*) FROM follows WHERE followee_id = $1) AS followers,
+    (SELECT COUNT(*) FROM follows WHERE follower_id = $1) AS following;
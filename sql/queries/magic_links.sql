@@ -0,0 +1,22 @@
+-- name: CreateMagicLink :exec
+INSERT INTO magic_links (id, created_at, user_id, email, expires_at, used_at)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4,
+    NULL
+);
+
+-- name: ConsumeMagicLink :one
+-- mark used and return who it was for in one go, so a link works only once
+UPDATE magic_links
+SET used_at = NOW()
+WHERE id = $1 AND used_at IS NULL AND expires_at > NOW()
+RETURNING user_id, email;
+
+-- name: InvalidateUserMagicLinks :exec
+UPDATE magic_links
+SET used_at = NOW()
+WHERE user_id = $1 AND used_at IS NULL;
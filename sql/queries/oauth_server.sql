@@ -0,0 +1,99 @@
+-- name: CreateOAuthClient :one
+INSERT INTO oauth_clients (id, created_at, owner_id, name, redirect_uris, secret_hash)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4
+)
+RETURNING *;
+
+-- name: GetOAuthClient :one
+SELECT * FROM oauth_clients
+WHERE id = $1;
+
+-- name: ListUserOAuthClients :many
+SELECT * FROM oauth_clients
+WHERE owner_id = $1
+ORDER BY created_at ASC;
+
+-- name: DeleteOAuthClient :execrows
+DELETE FROM oauth_clients
+WHERE id = $1 AND owner_id = $2;
+
+-- name: CreateAuthorizationCode :exec
+INSERT INTO oauth_authorization_codes (code_hash, created_at, client_id, user_id, redirect_uri, scopes, code_challenge, expires_at, used_at)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    $7,
+    NULL
+);
+
+-- name: ConsumeAuthorizationCode :one
+-- mark used and return it in one go, so a code works only once
+UPDATE oauth_authorization_codes
+SET used_at = NOW()
+WHERE code_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+RETURNING client_id, user_id, redirect_uri, scopes, code_challenge;
+
+-- name: CreateOAuthGrant :one
+INSERT INTO oauth_grants (id, created_at, client_id, user_id, scopes, refresh_token_hash, refresh_expires_at, last_used_at, revoked_at)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4,
+    $5,
+    NOW(),
+    NULL
+)
+RETURNING *;
+
+-- name: GetOAuthGrant :one
+SELECT * FROM oauth_grants
+WHERE id = $1;
+
+-- name: GetOAuthGrantByRefreshToken :one
+SELECT * FROM oauth_grants
+WHERE refresh_token_hash = $1;
+
+-- name: GetOAuthGrantByPreviousRefreshToken :one
+-- for reuse detection, see previous_refresh_token_hash
+SELECT * FROM oauth_grants
+WHERE previous_refresh_token_hash = $1;
+
+-- name: RotateOAuthGrantRefreshToken :execrows
+-- 0 rows = revoked, or someone else refreshed with this token first
+UPDATE oauth_grants
+SET refresh_token_hash = @new_refresh_token_hash,
+    previous_refresh_token_hash = refresh_token_hash,
+    refresh_expires_at = @refresh_expires_at,
+    last_used_at = NOW()
+WHERE id = @id AND refresh_token_hash = @old_refresh_token_hash AND revoked_at IS NULL;
+
+-- name: RevokeOAuthGrant :exec
+UPDATE oauth_grants
+SET revoked_at = NOW()
+WHERE id = $1 AND revoked_at IS NULL;
+
+-- name: RevokeUserOAuthGrant :execrows
+UPDATE oauth_grants
+SET revoked_at = NOW()
+WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL;
+
+-- name: ListUserOAuthGrants :many
+SELECT oauth_grants.id, oauth_grants.created_at, oauth_grants.last_used_at, oauth_grants.scopes, oauth_clients.id AS client_id, oauth_clients.name AS client_name
+FROM oauth_grants
+JOIN oauth_clients ON oauth_clients.id = oauth_grants.client_id
+WHERE oauth_grants.user_id = $1 AND oauth_grants.revoked_at IS NULL
+ORDER BY oauth_grants.created_at DESC;
@@ -0,0 +1,44 @@
+-- name: CreateOAuthState :exec
+INSERT INTO oauth_states (state_hash, created_at, provider, code_verifier, nonce, user_id, expires_at, used_at)
+VALUES (
+    $1,
+    NOW(),
+    $2,
+    $3,
+    $4,
+    $5,
+    $6,
+    NULL
+);
+
+-- name: ConsumeOAuthState :one
+-- mark used and return it in one go, so a state works only once
+UPDATE oauth_states
+SET used_at = NOW()
+WHERE state_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+RETURNING provider, code_verifier, nonce, user_id;
+
+-- name: CreateUserIdentity :one
+INSERT INTO user_identities (id, created_at, user_id, provider, subject, email)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    $1,
+    $2,
+    $3,
+    $4
+)
+RETURNING *;
+
+-- name: GetUserIdentity :one
+SELECT * FROM user_identities
+WHERE provider = $1 AND subject = $2;
+
+-- name: ListUserIdentities :many
+SELECT * FROM user_identities
+WHERE user_id = $1
+ORDER BY created_at ASC;
+
+-- name: DeleteUserIdentity :execrows
+DELETE FROM user_identities
+WHERE id = $1 AND user_id = $2;
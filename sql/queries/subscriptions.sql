@@ -0,0 +1,46 @@
+-- name: StartSubscription :one
+-- a new membership, or a comeback after it ended
+INSERT INTO subscriptions (id, created_at, updated_at, user_id, status, current_period_start, current_period_end)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    'active',
+    $2,
+    $3
+)
+ON CONFLICT (user_id) DO UPDATE
+SET status = 'active',
+    current_period_start = EXCLUDED.current_period_start,
+    current_period_end = EXCLUDED.current_period_end,
+    updated_at = NOW()
+RETURNING *;
+
+-- name: RenewSubscription :execrows
+-- never moves the end back, in case an older renewal arrives late. 0 rows = no subscription.
+-- an ended one (canceled, refunded, expired) only comes back for a period that starts
+-- after it ended, a late or retried renewal from before doesn't undo a refund
+UPDATE subscriptions
+SET status = CASE WHEN status = 'active' OR @period_start >= current_period_end THEN 'active' ELSE status END,
+    current_period_start = CASE WHEN status = 'active' OR @period_start >= current_period_end THEN @period_start ELSE current_period_start END,
+    current_period_end = CASE WHEN status = 'active' OR @period_start >= current_period_end THEN GREATEST(current_period_end, @period_end) ELSE current_period_end END,
+    updated_at = NOW()
+WHERE user_id = @user_id;
+
+-- name: EndSubscription :execrows
+-- downgraded, refunded or expired: over right now
+UPDATE subscriptions
+SET status = @status,
+    current_period_end = LEAST(current_period_end, NOW()),
+    updated_at = NOW()
+WHERE user_id = @user_id AND status = 'active';
+
+-- name: ExpireLapsedSubscriptions :many
+-- period ended without a renewal. only bookkeeping, Chirpy Red already
+-- ended with the period (see user_is_chirpy_red)
+UPDATE subscriptions
+SET status = 'expired',
+    updated_at = NOW()
+WHERE status = 'active' AND current_period_end <= NOW()
+RETURNING user_id;
@@ -0,0 +1,66 @@
+-- name: ListTimelineByJoin :many
+-- fan-out-on-read: chirps of the user and whoever they follow, newest first.
+-- the cursor is the (publish_at, id) of the last chirp seen, both NULL on the first page
+SELECT * FROM chirps
+WHERE (user_id = sqlc.arg(user_id)::uuid
+        OR user_id IN (SELECT followee_id FROM follows WHERE follower_id = sqlc.arg(user_id)))
+    AND publish_at <= NOW() AND deleted_at IS NULL
+    AND (sqlc.narg(before_publish_at)::timestamp IS NULL
+        OR (publish_at, id) < (sqlc.narg(before_publish_at)::timestamp, sqlc.narg(before_id)::uuid))
+ORDER BY publish_at DESC, id DESC
+LIMIT sqlc.arg(max_results);
+
+-- name: ListTimelineEntries :many
+-- fan-out-on-write: the same page, from the precomputed rows
+SELECT chirps.* FROM timeline_entries
+JOIN chirps ON chirps.id = timeline_entries.chirp_id
+WHERE timeline_entries.user_id = sqlc.arg(user_id)
+    AND timeline_entries.publish_at <= NOW() AND chirps.deleted_at IS NULL
+    AND (sqlc.narg(before_publish_at)::timestamp IS NULL
+        OR (timeline_entries.publish_at, timeline_entries.chirp_id) < (sqlc.narg(before_publish_at)::timestamp, sqlc.narg(before_id)::uuid))
+ORDER BY timeline_entries.publish_at DESC, timeline_entries.chirp_id DESC
+LIMIT sqlc.arg(max_results);
+
+-- name: FanOutChirp :exec
+-- a new chirp goes to the author's timeline and every follower's
+INSERT INTO timeline_entries (user_id, chirp_id, author_id, publish_at)
+SELECT follower_id, sqlc.arg(chirp_id)::uuid, sqlc.arg(author_id)::uuid, sqlc.arg(publish_at)::timestamp
+FROM follows WHERE followee_id = sqlc.arg(author_id)
+UNION ALL
+SELECT sqlc.arg(author_id), sqlc.arg(chirp_id), sqlc.arg(author_id), sqlc.arg(publish_at)
+ON CONFLICT (user_id, chirp_id) DO NOTHING;
+
+-- name: BackfillTimeline :exec
+-- after a follow, the author's latest chirps show up right away
+INSERT INTO timeline_entries (user_id, chirp_id, author_id, publish_at)
+SELECT sqlc.arg(user_id)::uuid, id, user_id, publish_at FROM chirps
+WHERE user_id = sqlc.arg(author_id)::uuid
+ORDER BY publish_at DESC
+LIMIT sqlc.arg(max_results)
+ON CONFLICT (user_id, chirp_id) DO NOTHING;
+
+-- name: RemoveTimelineAuthor :exec
+-- after an unfollow
+DELETE FROM timeline_entries
+WHERE user_id = $1 AND author_id = $2;
+
+-- name: PruneTimelineEntries :execrows
+-- rows of follows that are gone, e.g. unfollowed while on fan-out-on-read
+DELETE FROM timeline_entries
+WHERE author_id <> user_id
+    AND NOT EXISTS (
+        SELECT 1 FROM follows
+        WHERE follows.follower_id = timeline_entries.user_id AND follows.followee_id = timeline_entries.author_id
+    );
+
+-- name: FillTimelineEntries :execrows
+-- every row that should be there, for switching to fan-out-on-write.
+-- tombstones don't show up in timelines (and may have no author)
+INSERT INTO timeline_entries (user_id, chirp_id, author_id, publish_at)
+SELECT follows.follower_id, chirps.id, chirps.user_id, chirps.publish_at
+FROM follows JOIN chirps ON chirps.user_id = follows.followee_id
+WHERE chirps.deleted_at IS NULL
+UNION ALL
+SELECT user_id, id, user_id, publish_at FROM chirps
+WHERE deleted_at IS NULL
+ON CONFLICT (user_id, chirp_id) DO NOTHING;
@@ -1,3 +1,6 @@
+-- is_chirpy_red isn't a column, it's derived from subscriptions (see
+-- user_is_chirpy_red), so every query lists the columns of User with it in place
+
 -- name: CreateUser :one
 INSERT INTO users (id, created_at, updated_at, email, hashed_password)
 VALUES (
@@ -7,23 +10,118 @@ VALUES (
     $1,
     $2
 )
-RETURNING *;
+RETURNING id, created_at, updated_at, email, hashed_password, user_is_chirpy_red(id) AS is_chirpy_red, email_verified_at, pending_email, totp_secret, totp_enabled_at, totp_last_step, role, handle, display_name, bio, location, website;
+
+-- name: CreateExternalUser :one
+-- user from an OIDC login, hashed_password keeps its 'unset' default
+INSERT INTO users (id, created_at, updated_at, email, email_verified_at)
+VALUES (
+    gen_random_uuid(),
+    NOW(),
+    NOW(),
+    $1,
+    $2
+)
+RETURNING id, created_at, updated_at, email, hashed_password, user_is_chirpy_red(id) AS is_chirpy_red, email_verified_at, pending_email, totp_secret, totp_enabled_at, totp_last_step, role, handle, display_name, bio, location, website;
 
 -- name: ResetUser :exec
 DELETE FROM users;
 
 -- name: GetUserByEmail :one
-SELECT * FROM users
+SELECT id, created_at, updated_at, email, hashed_password, user_is_chirpy_red(id) AS is_chirpy_red, email_verified_at, pending_email, totp_secret, totp_enabled_at, totp_last_step, role, handle, display_name, bio, location, website FROM users
 WHERE email = $1;
 
--- name: UpdateUserEmailPassword :one
+-- name: GetUserByID :one
+SELECT id, created_at, updated_at, email, hashed_password, user_is_chirpy_red(id) AS is_chirpy_red, email_verified_at, pending_email, totp_secret, totp_enabled_at, totp_last_step, role, handle, display_name, bio, location, website FROM users
+WHERE id = $1;
+
+-- name: UpdateUserPassword :exec
+UPDATE users
+SET hashed_password = $1, updated_at = NOW()
+WHERE id = $2;
+
+-- name: RehashUserPassword :exec
+-- same password, newer hash. skipped if the password changed meanwhile
+UPDATE users
+SET hashed_password = @new_hashed_password
+WHERE id = @id AND hashed_password = @old_hashed_password;
+
+-- name: SetUserPendingEmail :one
+UPDATE users
+SET pending_email = $1, updated_at = NOW()
+WHERE id = $2
+RETURNING id, created_at, updated_at, email, hashed_password, user_is_chirpy_red(id) AS is_chirpy_red, email_verified_at, pending_email, totp_secret, totp_enabled_at, totp_last_step, role, handle, display_name, bio, location, website;
+
+-- name: VerifyUserEmail :one
+-- the verified address becomes the email, pending one is done
+UPDATE users
+SET email = $1,
+    email_verified_at = NOW(),
+    pending_email = NULL,
+    updated_at = NOW()
+WHERE id = $2
+RETURNING id, created_at, updated_at, email, hashed_password, user_is_chirpy_red(id) AS is_chirpy_red, email_verified_at, pending_email, totp_secret, totp_enabled_at, totp_last_step, role, handle, display_name, bio, location, website;
+
+-- name: SetUserTOTPSecret :exec
+UPDATE users
+SET totp_secret = $1, totp_enabled_at = NULL, updated_at = NOW()
+WHERE id = $2;
+
+-- name: EnableUserTOTP :exec
+UPDATE users
+SET totp_enabled_at = NOW(), updated_at = NOW()
+WHERE id = $1;
+
+-- name: UseTOTPStep :execrows
+-- a code works only once, 0 rows means this step (or a later one) was used
 UPDATE users
-SET email = $1, hashed_password = $2
-WHERE id = $3
-RETURNING *;
+SET totp_last_step = $1
+WHERE id = $2 AND totp_last_step < $1;
 
--- name: ReddenUserByID :exec
+-- name: SetUserRole :one
 UPDATE users
-SET is_chirpy_red = true
+SET role = $1, updated_at = NOW()
+WHERE id = $2
+RETURNING id, created_at, updated_at, email, hashed_password, user_is_chirpy_red(id) AS is_chirpy_red, email_verified_at, pending_email, totp_secret, totp_enabled_at, totp_last_step, role, handle, display_name, bio, location, website;
+
+-- name: SetUserRoleByEmail :execrows
+-- only a verified email, or anyone signing up first with it would get the role
+UPDATE users
+SET role = $1, updated_at = NOW()
+WHERE email = $2 AND email_verified_at IS NOT NULL;
+
+-- name: ListUsers :many
+SELECT id, created_at, updated_at, email, hashed_password, user_is_chirpy_red(id) AS is_chirpy_red, email_verified_at, pending_email, totp_secret, totp_enabled_at, totp_last_step, role, handle, display_name, bio, location, website FROM users
+ORDER BY created_at ASC
+LIMIT $1 OFFSET $2;
+
+-- name: LockUser :one
+-- holds the user's row until the tx ends. new chirps of theirs wait for it
+-- (their foreign key check locks it)
+SELECT id FROM users
+WHERE id = $1
+FOR UPDATE;
+
+-- name: DeleteUserByID :execrows
+DELETE FROM users
 WHERE id = $1;
 
+-- name: GetUserByHandle :one
+SELECT id, created_at, updated_at, email, hashed_password, user_is_chirpy_red(id) AS is_chirpy_red, email_verified_at, pending_email, totp_secret, totp_enabled_at, totp_last_step, role, handle, display_name, bio, location, website FROM users
+WHERE lower(handle) = lower($1);
+
+-- name: UpdateUserProfile :one
+UPDATE users
+SET handle = $1,
+    display_name = $2,
+    bio = $3,
+    location = $4,
+    website = $5,
+    updated_at = NOW()
+WHERE id = $6
+RETURNING id, created_at, updated_at, email, hashed_password, user_is_chirpy_red(id) AS is_chirpy_red, email_verified_at, pending_email, totp_secret, totp_enabled_at, totp_last_step, role, handle, display_name, bio, location, website;
+
+-- name: GetUserSummaries :many
+-- authors of a page of chirps in one query
+SELECT id, handle, display_name, user_is_chirpy_red(id) AS is_chirpy_red FROM users
+WHERE id = ANY(sqlc.arg(ids)::uuid[]);
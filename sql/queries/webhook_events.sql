@@ -0,0 +1,10 @@
+-- name: RecordWebhookEvent :execrows
+-- 0 rows = we had it already
+INSERT INTO webhook_events (source, event_id, event, received_at)
+VALUES (
+    $1,
+    $2,
+    $3,
+    NOW()
+)
+ON CONFLICT (source, event_id) DO NOTHING;
@@ -0,0 +1,16 @@
+-- +goose Up
+-- every login starts a family, every refresh adds a child and retires the parent
+ALTER TABLE refresh_tokens
+ADD family_id UUID NOT NULL DEFAULT gen_random_uuid(),
+ADD parent_token TEXT REFERENCES refresh_tokens (token) ON DELETE SET NULL,
+ADD rotated_at TIMESTAMP;
+
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+
+-- +goose Down
+DROP INDEX refresh_tokens_family_id_idx;
+
+ALTER TABLE refresh_tokens
+DROP COLUMN rotated_at,
+DROP COLUMN parent_token,
+DROP COLUMN family_id;
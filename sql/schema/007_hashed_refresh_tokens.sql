@@ -0,0 +1,16 @@
+-- +goose Up
+-- store SHA-256 of refresh tokens instead of the raw token.
+-- existing rows get rehashed in place, so nobody gets logged out.
+ALTER TABLE refresh_tokens RENAME COLUMN token TO token_hash;
+ALTER TABLE refresh_tokens RENAME COLUMN parent_token TO parent_token_hash;
+
+UPDATE refresh_tokens
+SET token_hash = encode(sha256(convert_to(token_hash, 'UTF8')), 'hex'),
+    parent_token_hash = encode(sha256(convert_to(parent_token_hash, 'UTF8')), 'hex');
+
+-- +goose Down
+-- hashes can't be turned back into tokens, so everyone has to log in again
+DELETE FROM refresh_tokens;
+
+ALTER TABLE refresh_tokens RENAME COLUMN parent_token_hash TO parent_token;
+ALTER TABLE refresh_tokens RENAME COLUMN token_hash TO token;
@@ -0,0 +1,16 @@
+-- +goose Up
+-- a session = a refresh token family, remember where it's used from
+ALTER TABLE refresh_tokens
+ADD user_agent TEXT NOT NULL DEFAULT '',
+ADD ip_address TEXT NOT NULL DEFAULT '',
+ADD last_used_at TIMESTAMP NOT NULL DEFAULT NOW();
+
+CREATE INDEX refresh_tokens_user_id_idx ON refresh_tokens (user_id);
+
+-- +goose Down
+DROP INDEX refresh_tokens_user_id_idx;
+
+ALTER TABLE refresh_tokens
+DROP COLUMN last_used_at,
+DROP COLUMN ip_address,
+DROP COLUMN user_agent;
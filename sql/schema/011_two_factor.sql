@@ -0,0 +1,22 @@
+-- +goose Up
+ALTER TABLE users
+ADD totp_secret TEXT, -- base32, set at setup, enabled once a code is verified
+ADD totp_enabled_at TIMESTAMP,
+ADD totp_last_step BIGINT NOT NULL DEFAULT 0; -- last used time step, no replays
+
+CREATE TABLE recovery_codes (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    used_at TIMESTAMP,
+    UNIQUE (user_id, code_hash)
+);
+
+-- +goose Down
+DROP TABLE recovery_codes;
+
+ALTER TABLE users
+DROP COLUMN totp_last_step,
+DROP COLUMN totp_enabled_at,
+DROP COLUMN totp_secret;
@@ -0,0 +1,13 @@
+-- +goose Up
+-- the link itself is a signed token, we only keep its id (jti) to make it single-use
+CREATE TABLE magic_links (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    email TEXT NOT NULL, -- the email it was sent to
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE magic_links;
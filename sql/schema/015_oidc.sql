@@ -0,0 +1,28 @@
+-- +goose Up
+-- one OIDC login (or link) in progress, between /start and /callback
+CREATE TABLE oauth_states (
+    state_hash TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    provider TEXT NOT NULL,
+    code_verifier TEXT NOT NULL, -- PKCE
+    nonce TEXT NOT NULL,
+    user_id UUID REFERENCES users (id) ON DELETE CASCADE, -- set when linking to a logged in user
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP
+);
+
+-- an account at an external OIDC provider that can log in as a user
+CREATE TABLE user_identities (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    provider TEXT NOT NULL,
+    subject TEXT NOT NULL, -- "sub" of the ID token
+    email TEXT NOT NULL,
+    UNIQUE (provider, subject),
+    UNIQUE (user_id, provider)
+);
+
+-- +goose Down
+DROP TABLE user_identities;
+DROP TABLE oauth_states;
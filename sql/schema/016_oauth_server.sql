@@ -0,0 +1,42 @@
+-- +goose Up
+-- third-party apps that act for chirpy users (we are the authorization server here)
+CREATE TABLE oauth_clients (
+    id UUID PRIMARY KEY, -- the client_id
+    created_at TIMESTAMP NOT NULL,
+    owner_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    redirect_uris TEXT[] NOT NULL,
+    secret_hash TEXT -- NULL for public clients (PKCE only)
+);
+
+-- a user said yes to a client, holds the client's refresh token
+CREATE TABLE oauth_grants (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    client_id UUID NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    scopes TEXT[] NOT NULL,
+    refresh_token_hash TEXT NOT NULL UNIQUE, -- rotated on every refresh
+    refresh_expires_at TIMESTAMP NOT NULL,
+    last_used_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP
+);
+
+CREATE INDEX oauth_grants_user_id_idx ON oauth_grants (user_id);
+
+CREATE TABLE oauth_authorization_codes (
+    code_hash TEXT PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    client_id UUID NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    redirect_uri TEXT NOT NULL,
+    scopes TEXT[] NOT NULL,
+    code_challenge TEXT NOT NULL, -- PKCE S256
+    expires_at TIMESTAMP NOT NULL,
+    used_at TIMESTAMP
+);
+
+-- +goose Down
+DROP TABLE oauth_authorization_codes;
+DROP TABLE oauth_grants;
+DROP TABLE oauth_clients;
@@ -0,0 +1,12 @@
+-- +goose Up
+-- deliveries we already processed, so a retried or replayed one isn't applied twice
+CREATE TABLE webhook_events (
+    source TEXT NOT NULL, -- who sent it, e.g. 'polka'
+    event_id TEXT NOT NULL, -- the sender's id of the event
+    event TEXT NOT NULL,
+    received_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (source, event_id)
+);
+
+-- +goose Down
+DROP TABLE webhook_events;
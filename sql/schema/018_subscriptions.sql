@@ -0,0 +1,24 @@
+-- +goose Up
+-- Chirpy Red memberships, one row per user, kept up to date by Polka events.
+-- users.is_chirpy_red is derived from this now: active and not past its period end.
+-- only SyncUserChirpyRed writes it
+CREATE TABLE subscriptions (
+    id UUID PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    user_id UUID NOT NULL UNIQUE REFERENCES users (id) ON DELETE CASCADE,
+    status TEXT NOT NULL CHECK (status IN ('active', 'canceled', 'refunded', 'expired')),
+    current_period_start TIMESTAMP NOT NULL,
+    current_period_end TIMESTAMP NOT NULL
+);
+
+CREATE INDEX subscriptions_active_period_end_idx ON subscriptions (current_period_end) WHERE status = 'active';
+
+-- red users from before had no period, give them one, renewals take over from there
+INSERT INTO subscriptions (id, created_at, updated_at, user_id, status, current_period_start, current_period_end)
+SELECT gen_random_uuid(), NOW(), NOW(), id, 'active', NOW(), NOW() + INTERVAL '30 days'
+FROM users
+WHERE is_chirpy_red;
+
+-- +goose Down
+DROP TABLE subscriptions;
@@ -0,0 +1,17 @@
+-- +goose Up
+-- scheduled chirps: nobody sees a chirp before its publish_at
+ALTER TABLE chirps
+ADD publish_at TIMESTAMP;
+
+UPDATE chirps SET publish_at = created_at;
+
+ALTER TABLE chirps
+ALTER COLUMN publish_at SET NOT NULL;
+
+CREATE INDEX chirps_publish_at_idx ON chirps (publish_at);
+CREATE INDEX chirps_user_id_created_at_idx ON chirps (user_id, created_at);
+
+-- +goose Down
+DROP INDEX chirps_user_id_created_at_idx;
+ALTER TABLE chirps
+DROP COLUMN publish_at;
@@ -0,0 +1,41 @@
+-- +goose Up
+-- security log of account events. append-only: the trigger below refuses
+-- UPDATE, DELETE and TRUNCATE, so not even a bug (or an attacker with the app's
+-- db user) can quietly rewrite history.
+-- no foreign keys, the log has to outlive deleted users
+CREATE TABLE audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    created_at TIMESTAMP NOT NULL,
+    action TEXT NOT NULL,
+    outcome TEXT NOT NULL CHECK (outcome IN ('success', 'failure')),
+    actor_id UUID, -- who did it, NULL = unknown
+    target_id UUID, -- who it was done to, if not the actor
+    ip_address TEXT NOT NULL,
+    user_agent TEXT NOT NULL,
+    detail TEXT NOT NULL
+);
+
+CREATE INDEX audit_events_actor_id_idx ON audit_events (actor_id, id);
+CREATE INDEX audit_events_target_id_idx ON audit_events (target_id, id);
+CREATE INDEX audit_events_action_idx ON audit_events (action, id);
+CREATE INDEX audit_events_created_at_idx ON audit_events (created_at);
+
+-- +goose StatementBegin
+CREATE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER audit_events_no_change
+BEFORE UPDATE OR DELETE ON audit_events
+FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+
+CREATE TRIGGER audit_events_no_truncate
+BEFORE TRUNCATE ON audit_events
+FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only();
+
+-- +goose Down
+DROP TABLE audit_events;
+DROP FUNCTION audit_events_append_only;
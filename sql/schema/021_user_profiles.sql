@@ -0,0 +1,20 @@
+-- +goose Up
+-- public profile. handle is optional (older accounts have none),
+-- unique ignoring case but stored as typed
+ALTER TABLE users
+ADD handle TEXT,
+ADD display_name TEXT NOT NULL DEFAULT '',
+ADD bio TEXT NOT NULL DEFAULT '',
+ADD location TEXT NOT NULL DEFAULT '',
+ADD website TEXT NOT NULL DEFAULT '';
+
+CREATE UNIQUE INDEX users_handle_lower_idx ON users (lower(handle));
+
+-- +goose Down
+DROP INDEX users_handle_lower_idx;
+ALTER TABLE users
+DROP COLUMN handle,
+DROP COLUMN display_name,
+DROP COLUMN bio,
+DROP COLUMN location,
+DROP COLUMN website;
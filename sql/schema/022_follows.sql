@@ -0,0 +1,16 @@
+-- +goose Up
+-- who follows whom. id is only there to page the lists in a stable order
+CREATE TABLE follows (
+    id BIGSERIAL PRIMARY KEY,
+    follower_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    followee_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL,
+    UNIQUE (follower_id, followee_id),
+    CHECK (follower_id <> followee_id)
+);
+
+-- the unique one covers "following" of a user, this one "followers"
+CREATE INDEX follows_followee_id_idx ON follows (followee_id, id);
+
+-- +goose Down
+DROP TABLE follows;
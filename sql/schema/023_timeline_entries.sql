@@ -0,0 +1,22 @@
+-- +goose Up
+-- precomputed home timelines, only used with TIMELINE_STRATEGY=write:
+-- a chirp gets one row per follower (and one for its author) when it's posted.
+-- author_id and publish_at are copies from chirps, so unfollowing and paging
+-- don't need a join
+CREATE TABLE timeline_entries (
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE, -- whose timeline
+    chirp_id UUID NOT NULL REFERENCES chirps (id) ON DELETE CASCADE,
+    author_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    publish_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, chirp_id)
+);
+
+CREATE INDEX timeline_entries_page_idx ON timeline_entries (user_id, publish_at DESC, chirp_id DESC);
+CREATE INDEX timeline_entries_author_idx ON timeline_entries (user_id, author_id);
+
+-- for the fan-out-on-read query, chirps of a few authors newest first
+CREATE INDEX chirps_user_id_publish_at_idx ON chirps (user_id, publish_at DESC, id DESC);
+
+-- +goose Down
+DROP INDEX chirps_user_id_publish_at_idx;
+DROP TABLE timeline_entries;
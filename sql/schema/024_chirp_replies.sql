@@ -0,0 +1,13 @@
+-- +goose Up
+-- a chirp can answer another one. SET NULL: deleting an account still removes
+-- its chirps, replies from others then start their own thread
+ALTER TABLE chirps ADD COLUMN in_reply_to UUID REFERENCES chirps (id) ON DELETE SET NULL;
+-- a deleted chirp that has replies stays as a tombstone (empty body), so the thread holds together
+ALTER TABLE chirps ADD COLUMN deleted_at TIMESTAMP;
+
+CREATE INDEX chirps_in_reply_to_idx ON chirps (in_reply_to, publish_at, id);
+
+-- +goose Down
+DROP INDEX chirps_in_reply_to_idx;
+ALTER TABLE chirps DROP COLUMN deleted_at;
+ALTER TABLE chirps DROP COLUMN in_reply_to;
@@ -0,0 +1,10 @@
+-- +goose Up
+-- the refresh token before the last rotation. if it shows up again, someone
+-- kept a copy (the app or a thief, we can't tell), so the grant gets revoked
+ALTER TABLE oauth_grants ADD COLUMN previous_refresh_token_hash TEXT;
+
+CREATE INDEX oauth_grants_previous_refresh_token_hash_idx ON oauth_grants (previous_refresh_token_hash);
+
+-- +goose Down
+DROP INDEX oauth_grants_previous_refresh_token_hash_idx;
+ALTER TABLE oauth_grants DROP COLUMN previous_refresh_token_hash;
@@ -0,0 +1,26 @@
+-- +goose Up
+-- Chirpy Red comes straight from the subscription, there's no stored flag to
+-- go stale between a period end and the expiry job anymore.
+-- user queries select user_is_chirpy_red(id) AS is_chirpy_red
+-- this replaces what 018 says about is_chirpy_red: SyncUserChirpyRed and the
+-- column are gone, subscriptions are the only record.
+-- about 018's backfill: red users from before had no period and we have no
+-- record of when they paid, so they got 30 days from that migration (a fresh
+-- period, erring on their side), Polka's next renewal takes over from there
+-- +goose StatementBegin
+CREATE FUNCTION user_is_chirpy_red(uid UUID) RETURNS BOOLEAN AS $$
+    SELECT EXISTS (
+        SELECT 1 FROM subscriptions
+        WHERE subscriptions.user_id = uid
+            AND subscriptions.status = 'active'
+            AND subscriptions.current_period_end > NOW()
+    );
+$$ LANGUAGE sql STABLE;
+-- +goose StatementEnd
+
+ALTER TABLE users DROP COLUMN is_chirpy_red;
+
+-- +goose Down
+ALTER TABLE users ADD COLUMN is_chirpy_red BOOLEAN NOT NULL DEFAULT false;
+UPDATE users SET is_chirpy_red = user_is_chirpy_red(id);
+DROP FUNCTION user_is_chirpy_red(UUID);
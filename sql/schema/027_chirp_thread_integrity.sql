@@ -0,0 +1,20 @@
+-- +goose Up
+-- replaces the ON DELETE SET NULL from 024, deleting an account cut its
+-- chirps out of other people's threads with it.
+-- replies hold on to what they reply to: a chirp with replies can only become a
+-- tombstone, the db refuses to delete it (NO ACTION is checked at the end of the
+-- statement, so a whole subthread can still go at once).
+-- deleting an account tombstones its chirps that have replies and takes the
+-- author off them (user_id NULL), the rest still cascade with the user
+ALTER TABLE chirps DROP CONSTRAINT chirps_in_reply_to_fkey;
+ALTER TABLE chirps ADD CONSTRAINT chirps_in_reply_to_fkey
+    FOREIGN KEY (in_reply_to) REFERENCES chirps (id) ON DELETE NO ACTION;
+ALTER TABLE chirps ALTER COLUMN user_id DROP NOT NULL;
+
+-- +goose Down
+ALTER TABLE chirps DROP CONSTRAINT chirps_in_reply_to_fkey;
+ALTER TABLE chirps ADD CONSTRAINT chirps_in_reply_to_fkey
+    FOREIGN KEY (in_reply_to) REFERENCES chirps (id) ON DELETE SET NULL;
+-- tombstones without an author can't stay
+DELETE FROM chirps WHERE user_id IS NULL;
+ALTER TABLE chirps ALTER COLUMN user_id SET NOT NULL;
@@ -0,0 +1,19 @@
+-- +goose Up
+-- one row per chirp posted, for the posts per hour limit. chirps themselves
+-- can't be counted: deleting them would give the posts back.
+-- only the last hour matters, older rows are pruned as the user posts
+CREATE TABLE chirp_posts (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    posted_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX chirp_posts_user_id_posted_at_idx ON chirp_posts (user_id, posted_at);
+
+-- what was counted before this, so the limit carries on
+INSERT INTO chirp_posts (user_id, posted_at)
+SELECT user_id, created_at FROM chirps
+WHERE user_id IS NOT NULL AND created_at > NOW() - INTERVAL '1 hour';
+
+-- +goose Down
+DROP TABLE chirp_posts;
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/WaronLimsakul/Chirpy/internal/audit"
+	"github.com/WaronLimsakul/Chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// header with the signature of a Polka delivery, see webhook.Verify
+const polkaSignatureHeader = "Polka-Signature"
+
+// when an event doesn't say how long the period is
+const subscriptionPeriod = time.Hour * 24 * 30
+
+// Polka webhook. body: {"id": "evt_...", "event": "user.upgraded",
+// "data": {"user_id": "...", "period_start": "<RFC 3339>", "period_end": "<RFC 3339>"}}
+// events:
+// - user.upgraded: membership starts (again)
+// - user.renewed: paid for another period
+// - user.downgraded, user.refunded, user.expired: membership is over right now
+//
+// 1. verify the signature over the raw body, before trusting anything in it
+// 2. ignore events we don't care about
+// 3. record the event id, a delivery we already have is acknowledged and skipped
+// 4. apply it, in the same transaction, so a failure lets Polka retry it.
+// is_chirpy_red follows from the subscription, nothing else to update
+func (cfg *apiConfig) polkaWebhook(w http.ResponseWriter, r *http.Request) {
+	// 1.
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	err = cfg.polkaWebhooks.Verify(r.Header.Get(polkaSignatureHeader), body, time.Now())
+	if err != nil {
+		log.Printf("error verifying polka webhook: %s", err)
+		w.WriteHeader(401)
+		return
+	}
+
+	type reqBodyStruct struct {
+		ID    string `json:"id"`
+		Event string `json:"event"`
+		Data  struct {
+			UserID      string     `json:"user_id"`
+			PeriodStart *time.Time `json:"period_start"`
+			PeriodEnd   *time.Time `json:"period_end"`
+		} `json:"data"`
+	}
+
+	reqBody := reqBodyStruct{}
+	err = json.Unmarshal(body, &reqBody)
+	if err != nil || reqBody.ID == "" {
+		log.Printf("error decoding body in polkaWebhook: %v", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	// 2.
+	switch reqBody.Event {
+	case "user.upgraded", "user.renewed", "user.downgraded", "user.refunded", "user.expired":
+	default:
+		w.WriteHeader(204)
+		return
+	}
+
+	userUUID, err := uuid.Parse(reqBody.Data.UserID)
+	if err != nil {
+		log.Printf("error parsing user uuid at polkaWebhook: %s", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	periodStart := time.Now()
+	if reqBody.Data.PeriodStart != nil {
+		periodStart = *reqBody.Data.PeriodStart
+	}
+	periodEnd := periodStart.Add(subscriptionPeriod)
+	if reqBody.Data.PeriodEnd != nil {
+		periodEnd = *reqBody.Data.PeriodEnd
+	}
+	if !periodEnd.After(periodStart) {
+		w.WriteHeader(400)
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("%s", err)
+		w.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	// 3.
+	recorded, err := qtx.RecordWebhookEvent(r.Context(), database.RecordWebhookEventParams{
+		Source:  "polka",
+		EventID: reqBody.ID,
+		Event:   reqBody.Event,
+	})
+	if err != nil {
+		log.Printf("error recording polka event: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if recorded == 0 {
+		log.Printf("polka event %s already processed", reqBody.ID)
+		w.WriteHeader(204)
+		return
+	}
+
+	// 4.
+	if _, err := qtx.GetUserByID(r.Context(), userUUID); err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	switch reqBody.Event {
+	case "user.upgraded":
+		_, err = qtx.StartSubscription(r.Context(), database.StartSubscriptionParams{
+			UserID:             userUUID,
+			CurrentPeriodStart: periodStart,
+			CurrentPeriodEnd:   periodEnd,
+		})
+	case "user.renewed":
+		var renewed int64
+		renewed, err = qtx.RenewSubscription(r.Context(), database.RenewSubscriptionParams{
+			PeriodStart: periodStart,
+			PeriodEnd:   periodEnd,
+			UserID:      userUUID,
+		})
+		// renewal of a membership we never heard of, start it
+		if err == nil && renewed == 0 {
+			_, err = qtx.StartSubscription(r.Context(), database.StartSubscriptionParams{
+				UserID:             userUUID,
+				CurrentPeriodStart: periodStart,
+				CurrentPeriodEnd:   periodEnd,
+			})
+		}
+	default:
+		statuses := map[string]string{
+			"user.downgraded": "canceled",
+			"user.refunded":   "refunded",
+			"user.expired":    "expired",
+		}
+		_, err = qtx.EndSubscription(r.Context(), database.EndSubscriptionParams{
+			Status: statuses[reqBody.Event],
+			UserID: userUUID,
+		})
+	}
+	if err != nil {
+		log.Printf("error applying polka event %s: %s", reqBody.ID, err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("%s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	cfg.audit(r, audit.Event{
+		Action:   audit.ActionSubscription,
+		TargetID: userUUID,
+		Detail:   fmt.Sprintf("polka %s (%s)", reqBody.Event, reqBody.ID),
+	})
+
+	w.WriteHeader(204)
+}
+
+// background job: mark memberships whose period is over without a renewal
+// as expired. only bookkeeping, is_chirpy_red is derived from the period
+// end already (see user_is_chirpy_red), so nobody keeps Red until it runs
+func (cfg *apiConfig) expireSubscriptions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := cfg.expireLapsedSubscriptions(ctx); err != nil {
+			log.Printf("error expiring subscriptions: %s", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (cfg *apiConfig) expireLapsedSubscriptions(ctx context.Context) error {
+	userIDs, err := cfg.dbQueries.ExpireLapsedSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+
+	if len(userIDs) > 0 {
+		log.Printf("expired %d subscriptions", len(userIDs))
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/WaronLimsakul/Chirpy/internal/database"
+	"github.com/WaronLimsakul/Chirpy/internal/timeline"
+	"github.com/google/uuid"
+)
+
+// how far up a thread we go from the chirp
+const maxThreadAncestors = 50
+
+// replies shown under each reply, below the paged direct ones,
+// and replies in all (direct ones included), deepest levels cut first
+const (
+	maxThreadChildren = 10
+	maxThreadReplies  = 500
+)
+
+// a chirp with what it replies to, and its replies as a tree. no token needed
+// ?depth= how many levels of replies (default 3, max 10), each reply with up to
+// maxThreadChildren of its own. the rest (deeper or more) are only in
+// reply_count, get that chirp's thread for them
+// the direct replies are paged, oldest first: ?limit= (default 20, max 100),
+// then ?after=<next_after of the last page>
+func (cfg *apiConfig) getChirpThread(w http.ResponseWriter, r *http.Request) {
+	// 1.
+	chirpID, err := uuid.Parse(r.PathValue("chirp_id"))
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	query := r.URL.Query()
+	depth := 3
+	if s := query.Get("depth"); s != "" {
+		depth, err = strconv.Atoi(s)
+		if err != nil || depth < 1 || depth > 10 {
+			w.WriteHeader(400)
+			return
+		}
+	}
+
+	limit := 20
+	if s := query.Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > 100 {
+			w.WriteHeader(400)
+			return
+		}
+	}
+
+	// same (publish_at, id) position as in timelines, here going forward
+	after := timeline.Cursor{}
+	if s := query.Get("after"); s != "" {
+		after, err = timeline.ParseCursor(s)
+		if err != nil {
+			w.WriteHeader(400)
+			return
+		}
+	}
+
+	// 2. a tombstone still has a thread
+	chirp, err := cfg.dbQueries.GetChirpByID(r.Context(), chirpID)
+	if err != nil || chirp.PublishAt.After(time.Now()) {
+		w.WriteHeader(404)
+		return
+	}
+
+	ancestors, err := cfg.dbQueries.GetChirpAncestors(r.Context(), database.GetChirpAncestorsParams{
+		ID:       chirpID,
+		MaxDepth: maxThreadAncestors,
+	})
+	if err != nil {
+		log.Printf("error getting chirp ancestors: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	params := database.GetChirpRepliesParams{
+		ParentID:    uuid.NullUUID{UUID: chirpID, Valid: true},
+		MaxResults:  int32(limit),
+		MaxDepth:    int32(depth),
+		MaxChildren: maxThreadChildren,
+		MaxTotal:    maxThreadReplies,
+	}
+	if !after.IsZero() {
+		params.AfterPublishAt = sql.NullTime{Time: after.PublishAt, Valid: true}
+		params.AfterID = uuid.NullUUID{UUID: after.ChirpID, Valid: true}
+	}
+	replies, err := cfg.dbQueries.GetChirpReplies(r.Context(), params)
+	if err != nil {
+		log.Printf("error getting chirp replies: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	// 3. authors and reply counts for all of them at once
+	all := append(ancestors, chirp)
+	var lastDirect database.Chirp
+	direct := 0
+	for _, reply := range replies {
+		replyChirp := database.Chirp{
+			ID:        reply.ID,
+			CreatedAt: reply.CreatedAt,
+			UpdatedAt: reply.UpdatedAt,
+			Body:      reply.Body,
+			UserID:    reply.UserID,
+			PublishAt: reply.PublishAt,
+			InReplyTo: reply.InReplyTo,
+			DeletedAt: reply.DeletedAt,
+		}
+		all = append(all, replyChirp)
+		if reply.Depth == 1 {
+			direct++
+			lastDirect = replyChirp
+		}
+	}
+
+	resChirps, err := cfg.expandChirps(r.Context(), all)
+	if err != nil {
+		log.Printf("error getting chirp authors: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	// 4.
+	type resBodyStruct struct {
+		Ancestors []Chirp       `json:"ancestors"` // the root first
+		Chirp     Chirp         `json:"chirp"`
+		Replies   []ThreadChirp `json:"replies"`
+		// pass as ?after= for the next page of direct replies, missing on the last one
+		NextAfter string `json:"next_after,omitempty"`
+	}
+	res := resBodyStruct{
+		Ancestors: resChirps[:len(ancestors)],
+		Chirp:     resChirps[len(ancestors)],
+		Replies:   buildReplyTree(chirpID, resChirps[len(ancestors)+1:]),
+	}
+	if direct == limit {
+		res.NextAfter = timeline.CursorOf(lastDirect).String()
+	}
+
+	resData, err := json.Marshal(res)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(resData)
+}
+
+// delete an account and (db cascades) everything of it, except its chirps
+// that have replies: those stay as tombstones without an author, so threads
+// under them hold together. 0 rows = no such user
+func (cfg *apiConfig) deleteUserKeepingThreads(ctx context.Context, userID uuid.UUID) (int64, error) {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	queries := cfg.dbQueries.WithTx(tx)
+
+	// lock the user (no new chirps) and their chirps (no new replies), so which
+	// ones have replies can't change before the delete, like deleteChirp
+	if _, err := queries.LockUser(ctx, userID); errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if _, err := queries.LockUserChirps(ctx, userID); err != nil {
+		return 0, err
+	}
+
+	if err := queries.TombstoneUserChirps(ctx, userID); err != nil {
+		return 0, err
+	}
+
+	deleted, err := queries.DeleteUserByID(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return deleted, tx.Commit()
+}
+
+// nest replies under what they reply to, keeping their order
+func buildReplyTree(rootID uuid.UUID, replies []Chirp) []ThreadChirp {
+	children := map[uuid.UUID][]Chirp{}
+	for _, reply := range replies {
+		children[*reply.InReplyTo] = append(children[*reply.InReplyTo], reply)
+	}
+
+	var build func(parentID uuid.UUID) []ThreadChirp
+	build = func(parentID uuid.UUID) []ThreadChirp {
+		nodes := []ThreadChirp{}
+		for _, child := range children[parentID] {
+			nodes = append(nodes, ThreadChirp{Chirp: child, Replies: build(child.ID)})
+		}
+		return nodes
+	}
+	return build(rootID)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/WaronLimsakul/Chirpy/internal/auth"
+	"github.com/WaronLimsakul/Chirpy/internal/database"
+	"github.com/WaronLimsakul/Chirpy/internal/timeline"
+)
+
+// the timeline with our strategy. pass the tx's queries when it's for a hook,
+// so the timeline changes with the chirp / follow or not at all
+func (cfg *apiConfig) timeline(queries *database.Queries) timeline.Timeline {
+	return timeline.New(cfg.timelineStrategy, queries)
+}
+
+// home timeline: published chirps of the user and whoever they follow, newest first
+// pages: ?limit= (default 20, max 100), then ?before=<next_before of the last page>
+func (cfg *apiConfig) getTimeline(w http.ResponseWriter, r *http.Request) {
+	claims, err := cfg.authenticate(r, auth.ScopeChirpsRead)
+	if err != nil {
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+
+	// 1.
+	query := r.URL.Query()
+	before := timeline.Cursor{}
+	if s := query.Get("before"); s != "" {
+		before, err = timeline.ParseCursor(s)
+		if err != nil {
+			w.WriteHeader(400)
+			return
+		}
+	}
+
+	limit := 20
+	if s := query.Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > 100 {
+			w.WriteHeader(400)
+			return
+		}
+	}
+
+	// 2.
+	chirps, err := cfg.timeline(cfg.dbQueries).Page(r.Context(), claims.UserID, before, limit)
+	if err != nil {
+		log.Printf("error getting timeline: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	// 3.
+	resChirps, err := cfg.expandChirps(r.Context(), chirps)
+	if err != nil {
+		log.Printf("error getting chirp authors: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	type resBodyStruct struct {
+		Chirps []Chirp `json:"chirps"`
+		// pass as ?before= for the next page, missing on the last one
+		NextBefore string `json:"next_before,omitempty"`
+	}
+	res := resBodyStruct{Chirps: resChirps}
+	if len(chirps) == limit {
+		res.NextBefore = timeline.CursorOf(chirps[len(chirps)-1]).String()
+	}
+
+	resData, err := json.Marshal(res)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(resData)
+}
+
+// fill timeline_entries when starting on fan-out-on-write
+func (cfg *apiConfig) rebuildTimelines(ctx context.Context) {
+	removed, added, err := cfg.timeline(cfg.dbQueries).Rebuild(ctx)
+	if err != nil {
+		log.Printf("error rebuilding timelines: %s", err)
+		return
+	}
+	log.Printf("timelines rebuilt: %d entries removed, %d added", removed, added)
+}
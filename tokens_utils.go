@@ -0,0 +1,178 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/WaronLimsakul/Chirpy/internal/auth"
+	"github.com/WaronLimsakul/Chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// database.PersonalAccessToken -> PersonalAccessToken, without the token
+func toPersonalAccessToken(pat database.PersonalAccessToken) PersonalAccessToken {
+	res := PersonalAccessToken{
+		ID:        pat.ID,
+		Name:      pat.Name,
+		Scopes:    pat.Scopes,
+		CreatedAt: pat.CreatedAt,
+	}
+	if pat.ExpiresAt.Valid {
+		res.ExpiresAt = &pat.ExpiresAt.Time
+	}
+	if pat.LastUsedAt.Valid {
+		res.LastUsedAt = &pat.LastUsedAt.Time
+	}
+	return res
+}
+
+// Request body has => "name", "scopes" and optional "expires_at" (RFC 3339)
+// 1. Only a login can make tokens (a token can't make more tokens)
+// 2. Validate name, scopes and expiry
+// 3. Save the hash, respond 201 with the token. It's shown only this once
+func (cfg *apiConfig) createPersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	// 1.
+	claims, err := cfg.authenticate(r, accountOnly)
+	if err != nil {
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+
+	// 2.
+	type reqBodyStruct struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	reqBody := reqBodyStruct{}
+	if err := json.NewDecoder(r.Body).Decode(&reqBody); err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	reqBody.Name = strings.TrimSpace(reqBody.Name)
+	if reqBody.Name == "" || len(reqBody.Name) > 100 {
+		w.WriteHeader(400)
+		return
+	}
+
+	scopes, err := auth.ParseScopes(reqBody.Scopes)
+	if err != nil {
+		log.Printf("%s", err)
+		w.WriteHeader(400)
+		return
+	}
+
+	expiresAt := sql.NullTime{}
+	if reqBody.ExpiresAt != nil {
+		if !reqBody.ExpiresAt.After(time.Now()) {
+			w.WriteHeader(400)
+			return
+		}
+		expiresAt = sql.NullTime{Time: reqBody.ExpiresAt.UTC(), Valid: true}
+	}
+
+	// 3.
+	token, err := auth.MakePersonalAccessToken()
+	if err != nil {
+		log.Printf("%s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	dbScopes := []string{}
+	for _, scope := range scopes {
+		dbScopes = append(dbScopes, string(scope))
+	}
+
+	pat, err := cfg.dbQueries.CreatePersonalAccessToken(r.Context(), database.CreatePersonalAccessTokenParams{
+		UserID:    claims.UserID,
+		Name:      reqBody.Name,
+		TokenHash: auth.HashToken(token),
+		Scopes:    dbScopes,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		log.Printf("error creating personal access token: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	res := toPersonalAccessToken(pat)
+	res.Token = token
+
+	resData, err := json.Marshal(res)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(201)
+	w.Write(resData)
+}
+
+// list the user's tokens that aren't revoked (expired ones too, so they can see why)
+func (cfg *apiConfig) listPersonalAccessTokens(w http.ResponseWriter, r *http.Request) {
+	claims, err := cfg.authenticate(r, accountOnly)
+	if err != nil {
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+
+	pats, err := cfg.dbQueries.ListUserPersonalAccessTokens(r.Context(), claims.UserID)
+	if err != nil {
+		log.Printf("error listing personal access tokens: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	resTokens := []PersonalAccessToken{}
+	for _, pat := range pats {
+		resTokens = append(resTokens, toPersonalAccessToken(pat))
+	}
+
+	resData, err := json.Marshal(resTokens)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(resData)
+}
+
+// revoke one token of the user, 404 if it's not theirs or already revoked
+func (cfg *apiConfig) revokePersonalAccessToken(w http.ResponseWriter, r *http.Request) {
+	claims, err := cfg.authenticate(r, accountOnly)
+	if err != nil {
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+
+	tokenID, err := uuid.Parse(r.PathValue("token_id"))
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	revoked, err := cfg.dbQueries.RevokePersonalAccessToken(r.Context(), database.RevokePersonalAccessTokenParams{
+		ID:     tokenID,
+		UserID: claims.UserID,
+	})
+	if err != nil {
+		log.Printf("error revoking personal access token: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if revoked == 0 {
+		w.WriteHeader(404)
+		return
+	}
+
+	w.WriteHeader(204)
+}
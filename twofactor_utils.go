@@ -0,0 +1,291 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/WaronLimsakul/Chirpy/internal/auth"
+	"github.com/WaronLimsakul/Chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	twoFactorChallengeLifetime = time.Minute * 5
+	recoveryCodeCount          = 10
+)
+
+// start enrolling 2FA, need an access token in header
+// - make a new TOTP secret (replaces an unconfirmed one)
+// - respond with the otpauth:// URI for the authenticator app
+// - 2FA is NOT on until POST /api/2fa/verify gets a valid code
+func (cfg *apiConfig) setupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	claims, err := cfg.authenticate(r, accountOnly)
+	if err != nil {
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+	userID := claims.UserID
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	if user.TotpEnabledAt.Valid {
+		w.WriteHeader(409)
+		return
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		log.Printf("%s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	secretParams := database.SetUserTOTPSecretParams{
+		TotpSecret: sql.NullString{String: secret, Valid: true},
+		ID:         userID,
+	}
+	if err := cfg.dbQueries.SetUserTOTPSecret(r.Context(), secretParams); err != nil {
+		log.Printf("error saving totp secret: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	type resBodyStruct struct {
+		Secret     string `json:"secret"`
+		OtpauthURI string `json:"otpauth_uri"`
+	}
+
+	res := resBodyStruct{
+		Secret:     secret,
+		OtpauthURI: auth.TOTPURI("Chirpy", user.Email, secret),
+	}
+	resData, err := json.Marshal(res)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(resData)
+}
+
+// finish enrolling 2FA with "code" from the app, need an access token in header
+// - turn 2FA on
+// - respond with one-time recovery codes, the only time we show them
+func (cfg *apiConfig) verifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	claims, err := cfg.authenticate(r, accountOnly)
+	if err != nil {
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+	userID := claims.UserID
+
+	type reqBodyStruct struct {
+		Code string `json:"code"`
+	}
+
+	var req reqBodyStruct
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	// no setup yet, or already on
+	if !user.TotpSecret.Valid || user.TotpEnabledAt.Valid {
+		w.WriteHeader(409)
+		return
+	}
+
+	if !cfg.checkTOTPCode(r, user, req.Code) {
+		w.WriteHeader(401)
+		return
+	}
+
+	recoveryCodes, err := auth.MakeRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		log.Printf("%s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("%s", err)
+		w.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	if err := qtx.DeleteUserRecoveryCodes(r.Context(), userID); err != nil {
+		log.Printf("error deleting recovery codes: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	for _, code := range recoveryCodes {
+		codeParams := database.CreateRecoveryCodeParams{
+			UserID:   userID,
+			CodeHash: auth.HashToken(code),
+		}
+		if err := qtx.CreateRecoveryCode(r.Context(), codeParams); err != nil {
+			log.Printf("error creating recovery code: %s", err)
+			w.WriteHeader(500)
+			return
+		}
+	}
+
+	if err := qtx.EnableUserTOTP(r.Context(), userID); err != nil {
+		log.Printf("error enabling totp: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("%s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	type resBodyStruct struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	resData, err := json.Marshal(resBodyStruct{RecoveryCodes: recoveryCodes})
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(resData)
+}
+
+// step 1 of a 2FA login: password was right, give a short-lived challenge token
+func (cfg *apiConfig) respondWithTwoFactorChallenge(w http.ResponseWriter, user database.User) {
+	challengeToken, err := cfg.keyring.MakeChallengeJWT(user.ID, twoFactorChallengeLifetime)
+	if err != nil {
+		log.Printf("%s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	type resBodyStruct struct {
+		TwoFactorRequired bool   `json:"two_factor_required"`
+		ChallengeToken    string `json:"challenge_token"`
+	}
+
+	res := resBodyStruct{
+		TwoFactorRequired: true,
+		ChallengeToken:    challengeToken,
+	}
+	resData, err := json.Marshal(res)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(resData)
+}
+
+// step 2 of a 2FA login
+// body has "challenge_token" and either "code" (TOTP) or "recovery_code"
+// valid -> same response as POST /api/login
+func (cfg *apiConfig) loginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	type reqBodyStruct struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+
+	var req reqBodyStruct
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		w.WriteHeader(400)
+		return
+	}
+
+	userID, err := cfg.keyring.ValidateChallengeJWT(req.ChallengeToken)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil || !user.TotpEnabledAt.Valid {
+		w.WriteHeader(401)
+		return
+	}
+
+	// a challenge token lives 5 minutes, plenty of time to guess 6 digits without this
+	if cfg.loginThrottled(w, r, user.Email) {
+		return
+	}
+
+	if req.RecoveryCode != "" {
+		if !cfg.useRecoveryCode(r, userID, req.RecoveryCode) {
+			cfg.loginFailed(r, user.Email)
+			w.WriteHeader(401)
+			return
+		}
+	} else if !cfg.checkTOTPCode(r, user, req.Code) {
+		cfg.loginFailed(r, user.Email)
+		w.WriteHeader(401)
+		return
+	}
+
+	cfg.loginSucceeded(user.Email)
+	cfg.respondWithLogin(w, r, user)
+}
+
+// true if code is valid now and wasn't used before
+func (cfg *apiConfig) checkTOTPCode(r *http.Request, user database.User, code string) bool {
+	step, err := auth.ValidateTOTP(code, user.TotpSecret.String, time.Now())
+	if err != nil {
+		return false
+	}
+
+	// burn the step, so a code seen over someone's shoulder can't be reused
+	stepParams := database.UseTOTPStepParams{
+		TotpLastStep: step,
+		ID:           user.ID,
+	}
+	used, err := cfg.dbQueries.UseTOTPStep(r.Context(), stepParams)
+	if err != nil {
+		log.Printf("error saving totp step: %s", err)
+		return false
+	}
+
+	return used == 1
+}
+
+// true if the recovery code belonged to the user and is now used up
+func (cfg *apiConfig) useRecoveryCode(r *http.Request, userID uuid.UUID, code string) bool {
+	codeParams := database.UseRecoveryCodeParams{
+		UserID:   userID,
+		CodeHash: auth.HashToken(auth.NormalizeRecoveryCode(code)),
+	}
+	used, err := cfg.dbQueries.UseRecoveryCode(r.Context(), codeParams)
+	if err != nil {
+		log.Printf("error using recovery code: %s", err)
+		return false
+	}
+
+	return used == 1
+}
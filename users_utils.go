@@ -1,11 +1,16 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/WaronLimsakul/Chirpy/internal/audit"
 	"github.com/WaronLimsakul/Chirpy/internal/auth"
 	"github.com/WaronLimsakul/Chirpy/internal/database"
 	"github.com/google/uuid"
@@ -13,6 +18,7 @@ import (
 
 // create user in db, we need "email" and "password" key in json body
 // - not return hashed password in response
+// - send a mail to verify the email
 func (cfg *apiConfig) createUser(w http.ResponseWriter, r *http.Request) {
 	type reqBodyStruct struct {
 		Email    string `json:"email"`
@@ -27,7 +33,11 @@ func (cfg *apiConfig) createUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hashedPassword, err := auth.HashPassword(req.Password)
+	if !cfg.checkNewPassword(w, req.Password) {
+		return
+	}
+
+	hashedPassword, err := cfg.passwordHasher.Hash(req.Password)
 	if err != nil {
 		log.Printf("%s", err)
 		w.WriteHeader(500)
@@ -47,14 +57,15 @@ func (cfg *apiConfig) createUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	taggedNewUser := User{
-		ID:          newUser.ID,
-		CreatedAt:   newUser.CreatedAt,
-		UpdatedAt:   newUser.UpdatedAt,
-		Email:       newUser.Email,
-		IsChirpyRed: newUser.IsChirpyRed,
+	cfg.audit(r, audit.Event{Action: audit.ActionUserCreated, ActorID: newUser.ID})
+
+	if err := cfg.sendEmailVerification(r.Context(), newUser.ID, newUser.Email); err != nil {
+		// the account exists anyway, user can ask again with POST /api/verify/resend
+		log.Printf("error sending email verification: %s", err)
 	}
 
+	taggedNewUser := toUser(newUser)
+
 	resData, err := json.Marshal(taggedNewUser)
 	if err != nil {
 		log.Println("error marshalling data")
@@ -67,14 +78,32 @@ func (cfg *apiConfig) createUser(w http.ResponseWriter, r *http.Request) {
 	return
 }
 
+// database.User -> User, the json one (no password hash)
+func toUser(user database.User) User {
+	return User{
+		ID:            user.ID,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+		Email:         user.Email,
+		IsChirpyRed:   user.IsChirpyRed,
+		EmailVerified: user.EmailVerifiedAt.Valid,
+		PendingEmail:  user.PendingEmail.String,
+		Role:          user.Role,
+		Handle:        user.Handle.String,
+		DisplayName:   user.DisplayName,
+		Bio:           user.Bio,
+		Location:      user.Location,
+		Website:       user.Website,
+	}
+}
+
 // Request body has => "password" and "email"
 // 0. Decode body
-// 1. Set expire duration
+// 1. Too many failures for this email or IP -> 429 with Retry-After
 // 2. Get user by email
 // 3. Compare password with the hash one
-// 4. Create access token for user
-// 5. Create refresh token for user
-// 6. Respond: Invalid password -> 401 , Valid -> 200
+// 4. If 2FA is on, respond with a challenge token instead (see loginTwoFactor)
+// 5. Respond: Unknown email or invalid password -> 401 , Valid -> 200 with tokens
 func (cfg *apiConfig) loginUser(w http.ResponseWriter, r *http.Request) {
 	// Go guarantee zeo-initialize, so int is 0 if we reqBodyStruct{}
 	type reqBodyStruct struct {
@@ -93,59 +122,154 @@ func (cfg *apiConfig) loginUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 1.
-	expiresIn := time.Hour
+	if cfg.loginThrottled(w, r, req.Email) {
+		return
+	}
 
 	// 2.
+	// unknown email must look exactly like a wrong password (same status,
+	// same hashing time), otherwise login tells who has an account
 	user, err := cfg.dbQueries.GetUserByEmail(r.Context(), req.Email)
 	if err != nil {
-		w.WriteHeader(400)
+		cfg.passwordHasher.CheckDummy(req.Password)
+		cfg.loginFailed(r, req.Email)
+		w.WriteHeader(401)
 		return
 	}
 
 	// 3.
-	unMatch := auth.CheckPasswordHash(req.Password, user.HashedPassword)
+	// Check, not CheckPasswordHash: accounts without a password cost the same too
+	unMatch := cfg.passwordHasher.Check(req.Password, user.HashedPassword)
 	if unMatch != nil {
+		cfg.loginFailed(r, req.Email)
 		w.WriteHeader(401)
 		return
 	}
 
+	// hash from an older policy (bcrypt, weaker params): this is the only
+	// moment we have the password, so upgrade it now. login works either way
+	if cfg.passwordHasher.NeedsRehash(user.HashedPassword) {
+		cfg.rehashPassword(r, user, req.Password)
+	}
+
 	// 4.
-	token, err := auth.MakeJWT(user.ID, cfg.tokenSecret, expiresIn)
-	if err != nil {
-		log.Printf("%s", err)
-		w.WriteHeader(500)
+	// the password was right, but the account isn't in until the TOTP code is,
+	// so failures keep counting until loginTwoFactor
+	if user.TotpEnabledAt.Valid {
+		cfg.respondWithTwoFactorChallenge(w, user)
 		return
 	}
 
 	// 5.
-	refreshToken, err := auth.MakeRefreshToken()
+	cfg.loginSucceeded(user.Email)
+	cfg.respondWithLogin(w, r, user)
+}
+
+func (cfg *apiConfig) rehashPassword(r *http.Request, user database.User, password string) {
+	newHash, err := cfg.passwordHasher.Hash(password)
 	if err != nil {
-		log.Printf("%s", err)
-		w.WriteHeader(500)
+		log.Printf("error rehashing password of %s: %s", user.ID, err)
 		return
 	}
 
-	refreshTokenParams := database.CreateRefreshTokenParams{
-		Token:     refreshToken,
-		UserID:    user.ID,
-		ExpiresAt: time.Now().Add(time.Hour * 1440), // 60 days
+	err = cfg.dbQueries.RehashUserPassword(r.Context(), database.RehashUserPasswordParams{
+		NewHashedPassword: newHash,
+		ID:                user.ID,
+		OldHashedPassword: user.HashedPassword,
+	})
+	if err != nil {
+		log.Printf("error rehashing password of %s: %s", user.ID, err)
 	}
+}
 
-	_, err = cfg.dbQueries.CreateRefreshToken(r.Context(), refreshTokenParams)
+// failures are counted by email, not user id, so unknown emails
+// get throttled just like real ones
+func loginAccountKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// true (and 429 already written) if this email or client IP has to wait
+func (cfg *apiConfig) loginThrottled(w http.ResponseWriter, r *http.Request, email string) bool {
+	now := time.Now()
+	wait := max(
+		cfg.loginAccountLimiter.Wait(loginAccountKey(email), now),
+		cfg.loginIPLimiter.Wait(clientIP(r), now),
+	)
+	if wait == 0 {
+		return false
+	}
+
+	cfg.audit(r, audit.Event{
+		Action:  audit.ActionLogin,
+		Outcome: audit.OutcomeFailure,
+		Detail:  fmt.Sprintf("throttled, email %q", email),
+	})
+
+	// round up, "Retry-After: 0" would invite an immediate retry
+	w.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+	w.WriteHeader(429)
+	return true
+}
+
+func (cfg *apiConfig) loginFailed(r *http.Request, email string) {
+	cfg.audit(r, audit.Event{
+		Action:  audit.ActionLogin,
+		Outcome: audit.OutcomeFailure,
+		Detail:  fmt.Sprintf("email %q", email),
+	})
+
+	now := time.Now()
+	if wait := cfg.loginAccountLimiter.Fail(loginAccountKey(email), now); wait > 0 {
+		log.Printf("login for %q throttled for %s", email, wait)
+	}
+	if wait := cfg.loginIPLimiter.Fail(clientIP(r), now); wait > 0 {
+		log.Printf("login from %s throttled for %s", clientIP(r), wait)
+	}
+}
+
+// only the account is forgiven. the IP keeps its failures, otherwise
+// logging into your own account would reset a guessing run on others
+func (cfg *apiConfig) loginSucceeded(email string) {
+	cfg.loginAccountLimiter.Reset(loginAccountKey(email))
+}
+
+// the user proved who they are: create access + refresh token and respond
+// 1. Set expire duration
+// 2. Create access token for user
+// 3. Create refresh token for user
+// 4. Respond 200 with LoggedInUser
+func (cfg *apiConfig) respondWithLogin(w http.ResponseWriter, r *http.Request, user database.User) {
+	// 1.
+	expiresIn := time.Hour
+
+	// 2.
+	token, err := cfg.keyring.MakeJWT(user.ID, auth.Role(user.Role), expiresIn)
 	if err != nil {
 		log.Printf("%s", err)
+		w.WriteHeader(500)
 		return
 	}
 
-	// 6.
+	// 3.
+	// a fresh login starts a new token family
+	refreshToken, err := cfg.issueRefreshToken(r, cfg.dbQueries, user.ID, uuid.New(), "")
+	if err != nil {
+		log.Printf("%s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	// 4.
 	resBody := LoggedInUser{
-		ID:           user.ID,
-		CreatedAt:    user.CreatedAt,
-		UpdatedAt:    user.UpdatedAt,
-		Email:        user.Email,
-		Token:        token,
-		RefreshToken: refreshToken,
-		IsChirpyRed:  user.IsChirpyRed,
+		ID:            user.ID,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+		Email:         user.Email,
+		Token:         token,
+		RefreshToken:  refreshToken,
+		IsChirpyRed:   user.IsChirpyRed,
+		EmailVerified: user.EmailVerifiedAt.Valid,
+		Role:          user.Role,
 	}
 
 	resData, err := json.Marshal(resBody)
@@ -154,49 +278,179 @@ func (cfg *apiConfig) loginUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	cfg.audit(r, audit.Event{Action: audit.ActionLogin, ActorID: user.ID})
+
 	w.WriteHeader(200)
 	w.Write(resData)
-	return
+}
+
+// refresh tokens live 60 days, counting from the last refresh
+const refreshTokenLifetime = time.Hour * 1440
+
+// make a refresh token and save its hash in db, return the raw token.
+// pass the parent token hash when rotating so we can trace the family,
+// queries can be a transaction one.
+// the request tells us which device/IP the session is on.
+func (cfg *apiConfig) issueRefreshToken(r *http.Request, queries *database.Queries, userID uuid.UUID, familyID uuid.UUID, parentTokenHash string) (string, error) {
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return "", err
+	}
+
+	refreshTokenParams := database.CreateRefreshTokenParams{
+		TokenHash:       auth.HashToken(refreshToken),
+		UserID:          userID,
+		ExpiresAt:       time.Now().Add(refreshTokenLifetime),
+		FamilyID:        familyID,
+		ParentTokenHash: sql.NullString{String: parentTokenHash, Valid: parentTokenHash != ""},
+		UserAgent:       truncate(r.UserAgent(), 512),
+		IpAddress:       clientIP(r),
+	}
+
+	_, err = queries.CreateRefreshToken(r.Context(), refreshTokenParams)
+	if err != nil {
+		return "", err
+	}
+
+	return refreshToken, nil
 }
 
 // 1. extract token from header
 // 2. look up in database
-// 3. create a new JWT
+// 3. if it was already rotated, someone replayed it -> revoke whole family
+// 4. retire it and create a child refresh token (in one transaction)
+// 5. create a new JWT
 func (cfg *apiConfig) refreshUser(w http.ResponseWriter, r *http.Request) {
+	// 1.
 	reqToken, err := auth.GetBearerToken(r.Header)
 	if err != nil {
 		w.WriteHeader(401)
 		return
 	}
 
-	refreshToken, err := cfg.dbQueries.GetRefreshToken(r.Context(), reqToken)
+	// 2.
+	// we only keep hashes, so look up by hash
+	refreshToken, err := cfg.dbQueries.GetRefreshToken(r.Context(), auth.HashToken(reqToken))
+	if err != nil {
+		cfg.audit(r, audit.Event{
+			Action:  audit.ActionTokenRefreshed,
+			Outcome: audit.OutcomeFailure,
+			Detail:  "unknown refresh token",
+		})
+		w.WriteHeader(401)
+		return
+	}
+
+	// 3.
+	// a retired token should never come back, either the client or
+	// an attacker has a stolen copy. We can't tell which, so kill them all.
+	if refreshToken.RotatedAt.Valid {
+		cfg.handleRefreshTokenReuse(r, refreshToken)
+		w.WriteHeader(401)
+		return
+	}
+
 	// check if
-	// 1. token exists
-	// 2. exceeds expire date
-	// 3. got revoked
-	if err != nil || refreshToken.ExpiresAt.Before(time.Now()) || refreshToken.RevokedAt.Valid {
+	// 1. exceeds expire date
+	// 2. got revoked
+	if refreshToken.ExpiresAt.Before(time.Now()) || refreshToken.RevokedAt.Valid {
+		cfg.audit(r, audit.Event{
+			Action:  audit.ActionTokenRefreshed,
+			Outcome: audit.OutcomeFailure,
+			ActorID: refreshToken.UserID,
+			Detail:  "expired or revoked refresh token",
+		})
+		w.WriteHeader(401)
+		return
+	}
+
+	// 4.
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("%s", err)
+		w.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback() // no-op after commit
+
+	qtx := cfg.dbQueries.WithTx(tx)
+	rotated, err := qtx.RotateRefreshToken(r.Context(), refreshToken.TokenHash)
+	if err != nil {
+		log.Printf("%s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	// someone else rotated it between our read and write = reuse too
+	if rotated == 0 {
+		tx.Rollback()
+		cfg.handleRefreshTokenReuse(r, refreshToken)
 		w.WriteHeader(401)
 		return
 	}
 
-	newToken, err := auth.MakeJWT(refreshToken.UserID, cfg.tokenSecret, time.Hour)
+	newRefreshToken, err := cfg.issueRefreshToken(r, qtx, refreshToken.UserID, refreshToken.FamilyID, refreshToken.TokenHash)
+	if err != nil {
+		log.Printf("%s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("%s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	// 5.
+	// role can change anytime, take it fresh from db
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), refreshToken.UserID)
+	if err != nil {
+		log.Printf("%s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	newToken, err := cfg.keyring.MakeJWT(user.ID, auth.Role(user.Role), time.Hour)
 	if err != nil {
 		w.WriteHeader(401)
 		return
 	}
 
+	cfg.audit(r, audit.Event{Action: audit.ActionTokenRefreshed, ActorID: user.ID})
+
 	type resBody struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 	res := resBody{
-		Token: newToken,
+		Token:        newToken,
+		RefreshToken: newRefreshToken,
 	}
 
 	resData, err := json.Marshal(res)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
 	w.WriteHeader(200)
 	w.Write(resData)
 }
 
+// a retired token came back: revoke every token of its family
+func (cfg *apiConfig) handleRefreshTokenReuse(r *http.Request, refreshToken database.RefreshToken) {
+	log.Printf("refresh token reuse detected, revoking family %s", refreshToken.FamilyID)
+	cfg.audit(r, audit.Event{
+		Action:  audit.ActionTokenRefreshed,
+		Outcome: audit.OutcomeFailure,
+		ActorID: refreshToken.UserID,
+		Detail:  fmt.Sprintf("reuse of a rotated refresh token, session %s revoked", refreshToken.FamilyID),
+	})
+	if err := cfg.dbQueries.RevokeTokenFamily(r.Context(), refreshToken.FamilyID); err != nil {
+		log.Printf("error revoking token family: %s", err)
+	}
+}
+
 // revoke refresh token from the request
 func (cfg *apiConfig) revokeToken(w http.ResponseWriter, r *http.Request) {
 	reqToken, err := auth.GetBearerToken(r.Header)
@@ -206,31 +460,34 @@ func (cfg *apiConfig) revokeToken(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = cfg.dbQueries.RevokeToken(r.Context(), reqToken)
+	err = cfg.dbQueries.RevokeToken(r.Context(), auth.HashToken(reqToken))
 	if err != nil {
 		log.Printf("%s", err)
 		w.WriteHeader(500)
 		return
 	}
 
+	// only to know whose it was, revoking an unknown token is fine
+	if refreshToken, err := cfg.dbQueries.GetRefreshToken(r.Context(), auth.HashToken(reqToken)); err == nil {
+		cfg.audit(r, audit.Event{Action: audit.ActionTokenRevoked, ActorID: refreshToken.UserID})
+	}
+
 	w.WriteHeader(204)
 	return
 }
 
 // update user data with new email and password
 // need an access token in header
+// - new email goes to pending_email and gets a verification mail
+// - "email" only changes after GET /api/verify
+// - 409 if someone else already has the new email
 func (cfg *apiConfig) updateUser(w http.ResponseWriter, r *http.Request) {
-	reqToken, err := auth.GetBearerToken(r.Header)
+	claims, err := cfg.authenticate(r, accountOnly)
 	if err != nil {
-		w.WriteHeader(401)
-		return
-	}
-
-	userID, err := auth.ValidateJWT(reqToken, cfg.tokenSecret)
-	if err != nil {
-		w.WriteHeader(401)
+		w.WriteHeader(authErrorStatus(err))
 		return
 	}
+	userID := claims.UserID
 
 	type reqBodyStruct struct {
 		Password string `json:"password"`
@@ -246,97 +503,111 @@ func (cfg *apiConfig) updateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	reqHashedPassword, err := auth.HashPassword(req.Password)
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), userID)
 	if err != nil {
-		log.Printf("error hashing password at updateUser: %s", err)
-		w.WriteHeader(500)
+		w.WriteHeader(404)
 		return
 	}
 
-	updateUserParams := database.UpdateUserEmailPasswordParams{
-		Email:          req.Email,
-		HashedPassword: reqHashedPassword,
-		ID:             userID,
+	if !cfg.checkNewPassword(w, req.Password) {
+		return
 	}
-	updatedUser, err := cfg.dbQueries.UpdateUserEmailPassword(r.Context(), updateUserParams)
+
+	reqHashedPassword, err := cfg.passwordHasher.Hash(req.Password)
 	if err != nil {
-		log.Printf("error updating user data: %s", err)
+		log.Printf("error hashing password at updateUser: %s", err)
 		w.WriteHeader(500)
 		return
 	}
 
-	res := User{
-		ID:          updatedUser.ID,
-		CreatedAt:   updatedUser.CreatedAt,
-		UpdatedAt:   updatedUser.UpdatedAt,
-		Email:       updatedUser.Email,
-		IsChirpyRed: updatedUser.IsChirpyRed,
+	// a new email is only pending until the user verifies it
+	emailChanged := req.Email != "" && req.Email != user.Email
+	if emailChanged {
+		_, err := cfg.dbQueries.GetUserByEmail(r.Context(), req.Email)
+		if err == nil {
+			w.WriteHeader(409)
+			return
+		}
 	}
 
-	resData, err := json.Marshal(res)
+	// all checked, now both changes or none
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
 	if err != nil {
-		log.Printf("error marshalling response at updateUser: %s", err)
+		log.Printf("%s", err)
 		w.WriteHeader(500)
 		return
 	}
+	defer tx.Rollback()
+	qtx := cfg.dbQueries.WithTx(tx)
 
-	w.WriteHeader(200)
-	w.Write(resData)
-	return
-}
-
-// we need
-// 1. "Authorization" = "ApiKey <key>" in the header
-// 2."event" = "user.upgraded"  in body
-// 3."data" = {"user_id" : "..."} in body
-func (cfg *apiConfig) reddenUser(w http.ResponseWriter, r *http.Request) {
-	reqAPIKey, err := auth.GetAPIKey(r.Header)
+	err = qtx.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+		HashedPassword: reqHashedPassword,
+		ID:             userID,
+	})
 	if err != nil {
-		log.Printf("error at reddenUser: %s", err)
-		w.WriteHeader(401)
+		log.Printf("error updating user data: %s", err)
+		w.WriteHeader(500)
 		return
 	}
 
-	if reqAPIKey != cfg.polkaKey {
-		w.WriteHeader(401)
-		return
-	}
+	verifyToken := ""
+	if emailChanged {
+		pendingParams := database.SetUserPendingEmailParams{
+			PendingEmail: sql.NullString{String: req.Email, Valid: true},
+			ID:           userID,
+		}
+		if _, err := qtx.SetUserPendingEmail(r.Context(), pendingParams); err != nil {
+			log.Printf("error updating user data: %s", err)
+			w.WriteHeader(500)
+			return
+		}
 
-	type reqBodyStruct struct {
-		Event string `json:"event"`
-		Data  struct {
-			UserID string `json:"user_id"`
-		} `json:"data"`
+		verifyToken, err = createEmailVerification(r.Context(), qtx, userID, req.Email)
+		if err != nil {
+			log.Printf("error creating email verification: %s", err)
+			w.WriteHeader(500)
+			return
+		}
 	}
 
-	reqBody := reqBodyStruct{}
-	decoder := json.NewDecoder(r.Body)
-	err = decoder.Decode(&reqBody)
-	if err != nil {
-		log.Printf("error decoding body in reddenUser: %s", err)
+	if err := tx.Commit(); err != nil {
+		log.Printf("%s", err)
 		w.WriteHeader(500)
 		return
 	}
 
-	if reqBody.Event != "user.upgraded" {
-		w.WriteHeader(204)
-		return
+	cfg.audit(r, audit.Event{Action: audit.ActionPasswordChanged, ActorID: userID})
+	if emailChanged {
+		cfg.audit(r, audit.Event{
+			Action:  audit.ActionEmailChange,
+			ActorID: userID,
+			Detail:  fmt.Sprintf("to %q", req.Email),
+		})
+
+		// the change is saved already, a lost mail can be sent again
+		// with POST /api/verify/resend
+		if err := cfg.mailVerificationLink(r.Context(), req.Email, verifyToken); err != nil {
+			log.Printf("error sending email verification: %s", err)
+		}
 	}
 
-	userUUID, err := uuid.Parse(reqBody.Data.UserID)
+	updatedUser, err := cfg.dbQueries.GetUserByID(r.Context(), userID)
 	if err != nil {
-		log.Printf("error parsing user uuid at ReddenUser: %s", err)
+		log.Printf("error getting updated user: %s", err)
 		w.WriteHeader(500)
 		return
 	}
 
-	err = cfg.dbQueries.ReddenUserByID(r.Context(), userUUID)
+	res := toUser(updatedUser)
+
+	resData, err := json.Marshal(res)
 	if err != nil {
-		log.Printf("error parsing user uuid at ReddenUser: %s", err)
-		w.WriteHeader(404)
+		log.Printf("error marshalling response at updateUser: %s", err)
+		w.WriteHeader(500)
 		return
 	}
 
-	w.WriteHeader(204)
+	w.WriteHeader(200)
+	w.Write(resData)
 	return
 }
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/WaronLimsakul/Chirpy/internal/auth"
+	"github.com/WaronLimsakul/Chirpy/internal/database"
+	"github.com/WaronLimsakul/Chirpy/internal/mailer"
+	"github.com/google/uuid"
+)
+
+const emailVerificationLifetime = time.Hour * 24
+
+// make a verification token for (user, email) and mail the link to that email.
+// older tokens of the user stop working, only the latest address counts.
+func (cfg *apiConfig) sendEmailVerification(ctx context.Context, userID uuid.UUID, email string) error {
+	verifyToken, err := createEmailVerification(ctx, cfg.dbQueries, userID, email)
+	if err != nil {
+		return err
+	}
+	return cfg.mailVerificationLink(ctx, email, verifyToken)
+}
+
+// the db half of sendEmailVerification, so it can be part of a transaction.
+// returns the token for the mail
+func createEmailVerification(ctx context.Context, queries *database.Queries, userID uuid.UUID, email string) (string, error) {
+	verifyToken, err := auth.MakeRefreshToken() // same thing, 256-bit random
+	if err != nil {
+		return "", err
+	}
+
+	err = queries.InvalidateUserEmailVerifications(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+
+	verificationParams := database.CreateEmailVerificationParams{
+		TokenHash: auth.HashToken(verifyToken),
+		UserID:    userID,
+		Email:     email,
+		ExpiresAt: time.Now().Add(emailVerificationLifetime),
+	}
+	err = queries.CreateEmailVerification(ctx, verificationParams)
+	if err != nil {
+		return "", err
+	}
+	return verifyToken, nil
+}
+
+// the mail half of sendEmailVerification
+func (cfg *apiConfig) mailVerificationLink(ctx context.Context, email, verifyToken string) error {
+	msg := mailer.Message{
+		To:      email,
+		Subject: "Verify your Chirpy email",
+		Body: fmt.Sprintf("Open this link to verify your email:\n\n%s/api/verify?token=%s\n\n"+
+			"It expires in %d hours. If you don't have a Chirpy account, just ignore this email.",
+			cfg.publicURL, url.QueryEscape(verifyToken), int(emailVerificationLifetime.Hours())),
+	}
+
+	return cfg.mailer.Send(ctx, msg)
+}
+
+// GET /api/verify?token=xxx, the link in the verification mail
+// - signup: mark email verified
+// - email change: pending email becomes the email
+func (cfg *apiConfig) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	reqToken := r.URL.Query().Get("token")
+	if reqToken == "" {
+		w.WriteHeader(400)
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("%s", err)
+		w.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+
+	qtx := cfg.dbQueries.WithTx(tx)
+
+	verification, err := qtx.ConsumeEmailVerification(r.Context(), auth.HashToken(reqToken))
+	if err != nil {
+		// not found, used or expired
+		w.WriteHeader(401)
+		return
+	}
+
+	verifyParams := database.VerifyUserEmailParams{
+		Email: verification.Email,
+		ID:    verification.UserID,
+	}
+	verifiedUser, err := qtx.VerifyUserEmail(r.Context(), verifyParams)
+	if err != nil {
+		// most likely someone else took this email in the meantime
+		log.Printf("error verifying email: %s", err)
+		w.WriteHeader(409)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("%s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	resData, err := json.Marshal(toUser(verifiedUser))
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(200)
+	w.Write(resData)
+}
+
+// send the verification mail again (pending email if there is one)
+// need an access token in header
+func (cfg *apiConfig) resendEmailVerification(w http.ResponseWriter, r *http.Request) {
+	claims, err := cfg.authenticate(r, accountOnly)
+	if err != nil {
+		w.WriteHeader(authErrorStatus(err))
+		return
+	}
+	userID := claims.UserID
+
+	user, err := cfg.dbQueries.GetUserByID(r.Context(), userID)
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+
+	email := user.Email
+	if user.PendingEmail.Valid {
+		email = user.PendingEmail.String
+	} else if user.EmailVerifiedAt.Valid {
+		// nothing to verify
+		w.WriteHeader(409)
+		return
+	}
+
+	if err := cfg.sendEmailVerification(r.Context(), user.ID, email); err != nil {
+		log.Printf("error sending email verification: %s", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	w.WriteHeader(202)
+}